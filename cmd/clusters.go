@@ -0,0 +1,146 @@
+// Package cmd
+// Copyright © 2022 Zeng Ganghui <zengganghui@gmail.com>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	k8client "k8res/internal/k8s/client"
+	"k8res/internal/process"
+	"k8res/pkg/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// clusterNames returns clusters to scan: app.clusters, or all clusters of the clusters settings,
+// or the default cluster "" if there are none. Cluster names are lower case like viper keys.
+func clusterNames() []string {
+	configured := viper.GetStringMap("clusters")
+	var names []string
+	for _, name := range viper.GetStringSlice("app.clusters") {
+		name = strings.ToLower(name)
+		if _, ok := configured[name]; !ok {
+			logger.Fatalf("unknown cluster %q, add it to clusters settings", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		return names
+	}
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+// forEachCluster runs fn with a started collector of each cluster concurrently, and waits for all.
+// A cluster which can not be started is added to warnings, unless it is the only cluster.
+func forEachCluster(ctx context.Context, clusters []string, warnings *process.Warnings, fn func(i int, collector *process.Collector)) {
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func(i int, cluster string) {
+			defer wg.Done()
+			collector, err := startCollector(ctx, cluster)
+			if err != nil {
+				failCluster(clusters, cluster, warnings, err)
+				return
+			}
+			defer collector.Stop()
+			fn(i, collector)
+		}(i, cluster)
+	}
+	wg.Wait()
+}
+
+// failCluster exits if cluster is the only cluster, other clusters go on without it
+func failCluster(clusters []string, cluster string, warnings *process.Warnings, err error) {
+	if len(clusters) == 1 {
+		logger.Fatalf("%v", err)
+	}
+	logger.Errorf("cluster %s: %v", cluster, err)
+	warnings.AddClusterError(cluster, err)
+}
+
+// starter a collector with informer caches, synced by Start
+type starter interface {
+	Start(ctx context.Context) error
+	Stop()
+	MetricsAvailable() bool
+}
+
+// startCollector starts a collector of a cluster
+func startCollector(ctx context.Context, cluster string) (*process.Collector, error) {
+	k8, err := k8client.NewE(cluster)
+	if err != nil {
+		return nil, err
+	}
+	collector, err := process.NewCollector(k8)
+	if err != nil {
+		return nil, fmt.Errorf("create collector failed: %w", err)
+	}
+	if err = start(ctx, cluster, collector); err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// startNodeCollector starts a node collector of a cluster
+func startNodeCollector(ctx context.Context, cluster string) (*process.NodeCollector, error) {
+	k8, err := k8client.NewE(cluster)
+	if err != nil {
+		return nil, err
+	}
+	collector, err := process.NewNodeCollector(k8)
+	if err != nil {
+		return nil, fmt.Errorf("create node collector failed: %w", err)
+	}
+	if err = start(ctx, cluster, collector); err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// start starts collector, prints a notice if usage can not be collected.
+// Caches of an unreachable cluster never sync, so the start is limited by app.scanTimeout.
+func start(ctx context.Context, cluster string, collector starter) error {
+	startCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout := viper.GetDuration("app.scanTimeout"); timeout > 0 {
+		startCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	if err := collector.Start(startCtx); err != nil {
+		collector.Stop()
+		return fmt.Errorf("start collector failed: %w", err)
+	}
+	if !collector.MetricsAvailable() {
+		fmt.Fprintf(os.Stderr, "NOTICE: %smetrics.k8s.io api is not available, only requests and limits are reported, usage is 0\n",
+			clusterPrefix(cluster))
+	}
+	return nil
+}
+
+// clusterPrefix returns "cluster <name>: " to prefix notices, empty for the default cluster
+func clusterPrefix(cluster string) string {
+	if cluster == "" {
+		return ""
+	}
+	return "cluster " + cluster + ": "
+}
+
+// clusterStatsFile returns usage statistics file of a cluster, stats.json is stats-<cluster>.json
+func clusterStatsFile(path, cluster string) string {
+	if cluster == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + cluster + ext
+}
@@ -3,10 +3,12 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/spf13/cobra"
-	k8client "k8res/internal/k8s/client"
+	"github.com/spf13/viper"
 	"k8res/internal/process"
-	//"k8res/internal/process"
+	"k8res/pkg/logger"
+	"os"
 )
 
 // exportCmd represents the export command
@@ -17,13 +19,64 @@ var exportCmd = &cobra.Command{
 	Run: exportStart,
 }
 
-func exportStart(*cobra.Command, []string) {
-	k8 := k8client.New("")
-	store := make(process.AllPodResStore)
-	if err := process.GetPodRes(k8, store); err != nil {
-		panic(err)
+func exportStart(cmd *cobra.Command, _ []string) {
+	ctx := cmd.Context()
+	checkExportOptions()
+	clusters := clusterNames()
+	stores := make([]process.ClusterStore, len(clusters))
+	reports := make([]*process.ScanReport, len(clusters))
+	warnings := process.NewWarnings()
+	forEachCluster(ctx, clusters, warnings, func(i int, collector *process.Collector) {
+		store := process.NewAllPodResStore()
+		report, err := collector.GetPodRes(ctx, store)
+		if err != nil {
+			failCluster(clusters, clusters[i], warnings, fmt.Errorf("scan pods failed: %w", err))
+			return
+		}
+		stores[i] = process.ClusterStore{Cluster: clusters[i], Store: store}
+		reports[i] = report
+	})
+	process.ExportClusterPodRes(scannedClusters(stores))
+	for _, report := range reports {
+		printUnscheduled(report)
+		warnings.Add(report)
+	}
+	exitOnWarnings(warnings)
+}
+
+// checkExportOptions exits on a bad export option, before a long scan
+func checkExportOptions() {
+	if err := process.CheckExportOptions(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
+
+// scannedClusters returns stores of clusters which were scanned
+func scannedClusters(stores []process.ClusterStore) []process.ClusterStore {
+	var scanned []process.ClusterStore
+	for _, store := range stores {
+		if store.Store != nil {
+			scanned = append(scanned, store)
+		}
+	}
+	return scanned
+}
+
+// printUnscheduled prints pods not scheduled yet with their requests to stderr, the unmet demand of the cluster
+func printUnscheduled(report *process.ScanReport) {
+	if report == nil || report.Unscheduled == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "NOTICE: %s%d pods are not scheduled, unmet requests: cpu %dm, mem %d bytes\n",
+		clusterPrefix(report.Cluster), report.Unscheduled, report.UnscheduledRequests.CPU.Current, report.UnscheduledRequests.Mem.Current)
+}
+
+// exitOnWarnings prints warnings to stderr, and exits with 1 if there are warnings and app.failOnWarnings is set
+func exitOnWarnings(warnings *process.Warnings) {
+	warnings.Print(os.Stderr)
+	if warnings.Len() > 0 && viper.GetBool("app.failOnWarnings") {
+		os.Exit(1)
 	}
-	process.ExportPodRes(store)
 }
 
 func init() {
@@ -5,19 +5,17 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/viper"
-	k8client "k8res/internal/k8s/client"
 	"k8res/internal/process"
 	"k8res/pkg/logger"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	interval int32
+	interval  int32
+	statsFile string
 )
 
 // monitorCmd represents the monitor command
@@ -29,32 +27,57 @@ var monitorCmd = &cobra.Command{
 }
 
 func monitorStart(cmd *cobra.Command, args []string) {
-	k8 := k8client.New("")
-	store := make(process.AllPodResStore)
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	done := make(chan interface{})
-	go func(done chan<- interface{}, sigCh <-chan os.Signal) {
-		for {
+	ctx := cmd.Context()
+	checkExportOptions()
+	clusters := clusterNames()
+	stores := make([]process.ClusterStore, len(clusters))
+	lastReports := make([]*process.ScanReport, len(clusters)) // last complete scan of each cluster
+	warnings := process.NewWarnings()
+	statsFile = viper.GetString("app.statsFile")
+	forEachCluster(ctx, clusters, warnings, func(i int, collector *process.Collector) {
+		store := process.NewAllPodResStore()
+		if statsFile != "" {
+			if err := process.LoadUsageStats(store, clusterStatsFile(statsFile, clusters[i])); err != nil {
+				logger.Fatalf("load usage stats failed: %v", err)
+			}
+		}
+		stores[i] = process.ClusterStore{Cluster: clusters[i], Store: store}
+		// ctx is cancelled by SIGINT/SIGTERM, an in-flight scan stops at once and keeps what it collected
+		for ctx.Err() == nil {
+			report, err := collector.GetPodRes(ctx, store)
+			if err != nil && ctx.Err() == nil {
+				logger.Error(err)
+			}
+			if err == nil {
+				lastReports[i] = report
+			}
+			warnings.Add(report)
+			if report.Duration > time.Duration(interval)*time.Second {
+				logger.Warnf("scan %s took %v, longer than interval %ds, try more workers", clusters[i], report.Duration, interval)
+			}
+			fmt.Fprint(os.Stderr, ".")
 			select {
-			case <-sigCh:
-				fmt.Println()
-				fmt.Println("monitor stopped")
-				done <- true
-				return
-			default:
-				if err := process.GetPodRes(k8, store); err != nil {
-					logger.Error(err)
-				}
-				fmt.Print(".")
-				time.Sleep(time.Duration(interval) * time.Second)
+			case <-ctx.Done():
+			case <-time.After(time.Duration(interval) * time.Second):
 			}
 		}
-	}(done, sigCh)
-	<-done
-	fmt.Println("EXPORT DATA:")
-	process.ExportPodRes(store)
+	})
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "monitor stopped")
+	fmt.Fprintln(os.Stderr, "EXPORT DATA:")
+	scanned := scannedClusters(stores)
+	process.ExportClusterPodRes(scanned)
+	for _, report := range lastReports {
+		printUnscheduled(report)
+	}
+	if statsFile != "" {
+		for _, c := range scanned {
+			if err := process.SaveUsageStats(c.Store, clusterStatsFile(statsFile, c.Cluster)); err != nil {
+				logger.Errorf("save usage stats failed: %v", err)
+			}
+		}
+	}
+	exitOnWarnings(warnings)
 }
 
 func init() {
@@ -65,5 +88,10 @@ func init() {
 		fmt.Printf("FATAIL: %s", err)
 		os.Exit(1)
 	}
+	monitorCmd.Flags().StringVar(&statsFile, "stats-file", "", "usage statistics file, merged on start and saved on stop")
+	if err := viper.BindPFlag("app.statsFile", monitorCmd.Flags().Lookup("stats-file")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
 
 }
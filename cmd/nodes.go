@@ -0,0 +1,44 @@
+// Package cmd
+// Copyright © 2022 Zeng Ganghui <zengganghui@gmail.com>
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"k8res/internal/process"
+	"k8res/pkg/logger"
+)
+
+// nodesCmd represents the nodes command
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "export nodes allocatable resource, pods requests and limits, and usage",
+	Long: `Export allocatable cpu, mem, ephemeral storage and pods of each node,
+with requests and limits of pods on the node and node usage, in percent of allocatable.
+Every pod bound to a node which is not succeeded or failed counts, in all namespaces:
+--namespaces, --selector and --phases don't apply. With --ephemeral-usage, ephemeral storage
+usage is read from kubelet summaries through the node proxy, which needs nodes/proxy get,
+otherwise it is 0.`,
+	Run: nodesStart,
+}
+
+func nodesStart(cmd *cobra.Command, _ []string) {
+	ctx := cmd.Context()
+	checkExportOptions()
+	collector, err := startNodeCollector(ctx, "")
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	defer collector.Stop()
+	nodes, report, err := collector.GetNodeRes(ctx)
+	if err != nil {
+		logger.Fatalf("list nodes failed: %v", err)
+	}
+	process.ExportNodeRes(nodes)
+	warnings := process.NewWarnings()
+	warnings.Add(report)
+	exitOnWarnings(warnings)
+}
+
+func init() {
+	rootCmd.AddCommand(nodesCmd)
+}
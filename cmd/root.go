@@ -3,8 +3,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,9 +19,30 @@ import (
 )
 
 var (
-	runMode    string
-	logLevel   string
-	namespaces []string
+	runMode     string
+	logLevel    string
+	namespaces  []string
+	level       string
+	output      string
+	collapse    bool
+	ephemeral   bool
+	workers     int
+	failOnWarn  bool
+	reqTimeout  time.Duration
+	scanTimeout time.Duration
+	qps         float64
+	burst       int
+	cliTimeout  time.Duration
+	retries     int
+	backoff     time.Duration
+	selector    string
+	fieldSel    string
+	excludeNs   []string
+	nsSelector  string
+	phases      []string
+	totals      bool
+	groupBy     []string
+	clusters    []string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -31,15 +56,22 @@ var rootCmd = &cobra.Command{
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The command context is cancelled by SIGINT/SIGTERM, a second signal kills the app.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	utils.PrintFullVersion()
+	// stdout is kept for export output
+	utils.PrintFullVersion(os.Stderr)
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVarP(&runMode, "mode", "m", "prod", "run mode with: prod, dev, test")
@@ -48,20 +80,125 @@ func init() {
 		fmt.Printf("FATAIL: %s", err)
 		os.Exit(1)
 	}
-	rootCmd.PersistentFlags().StringArrayVarP(&namespaces, "namespaces", "n", []string{"all"}, "use special namespace list or all")
+	rootCmd.PersistentFlags().StringSliceVar(&clusters, "clusters", nil, "clusters of clusters settings to scan concurrently by export and monitor, default all")
+	if err := viper.BindPFlag("app.clusters", rootCmd.PersistentFlags().Lookup("clusters")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringArrayVarP(&namespaces, "namespaces", "n", []string{"all"}, "namespaces to scan or all: names, globs (kube-*), regexps (re:^team-) or @group of app.namespaceGroups")
 	if err := viper.BindPFlag("app.namespaces", rootCmd.PersistentFlags().Lookup("namespaces")); err != nil {
 		fmt.Printf("FATAIL: %s", err)
 		os.Exit(1)
 	}
+	rootCmd.PersistentFlags().StringArrayVar(&excludeNs, "exclude-namespaces", nil, "namespaces not to scan: names, globs, regexps or @group")
+	if err := viper.BindPFlag("app.excludeNamespaces", rootCmd.PersistentFlags().Lookup("exclude-namespaces")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringVar(&nsSelector, "namespace-selector", "", "namespace label selector, e.g. team=data")
+	if err := viper.BindPFlag("app.namespaceSelector", rootCmd.PersistentFlags().Lookup("namespace-selector")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringVarP(&selector, "selector", "l", "", "pod label selector, e.g. app=payments")
+	if err := viper.BindPFlag("app.selector", rootCmd.PersistentFlags().Lookup("selector")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringVar(&fieldSel, "field-selector", "", "pod field selector, e.g. spec.nodeName=node-12")
+	if err := viper.BindPFlag("app.fieldSelector", rootCmd.PersistentFlags().Lookup("field-selector")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringSliceVar(&phases, "phases", []string{"Running"}, "pod phases to collect: Pending, Running, Succeeded, Failed, Unknown or all")
+	if err := viper.BindPFlag("app.phases", rootCmd.PersistentFlags().Lookup("phases")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringVar(&level, "level", "pod", "export rows level: pod, container, workload")
+	if err := viper.BindPFlag("app.level", rootCmd.PersistentFlags().Lookup("level")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "export format: text, csv, json")
+	if err := viper.BindPFlag("app.output", rootCmd.PersistentFlags().Lookup("output")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().BoolVar(&ephemeral, "ephemeral-usage", false, "collect ephemeral storage usage from kubelet stats, needs nodes/proxy get")
+	if err := viper.BindPFlag("app.ephemeralUsage", rootCmd.PersistentFlags().Lookup("ephemeral-usage")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().BoolVar(&collapse, "collapse-replaced", false, "fold gone pods replaced by a live pod of the same workload into one replaced row each workload")
+	if err := viper.BindPFlag("app.collapseReplaced", rootCmd.PersistentFlags().Lookup("collapse-replaced")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().BoolVar(&totals, "totals", false, "export namespace subtotals and cluster total after the rows, with -o json one object of rows and totals arrays")
+	if err := viper.BindPFlag("app.totals", rootCmd.PersistentFlags().Lookup("totals")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().StringArrayVar(&groupBy, "group-by", nil, "export sums by pod label values instead of rows, label:<key>, repeat for more keys")
+	if err := viper.BindPFlag("app.groupBy", rootCmd.PersistentFlags().Lookup("group-by")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().IntVarP(&workers, "workers", "w", 4, "number of namespaces collected concurrently")
+	if err := viper.BindPFlag("app.workers", rootCmd.PersistentFlags().Lookup("workers")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().BoolVar(&failOnWarn, "fail-on-warnings", false, "exit with 1 if any namespace, pod or node failed in scans")
+	if err := viper.BindPFlag("app.failOnWarnings", rootCmd.PersistentFlags().Lookup("fail-on-warnings")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().DurationVar(&reqTimeout, "request-timeout", 30*time.Second, "timeout of each metrics and kubelet request, 0 no timeout")
+	if err := viper.BindPFlag("app.requestTimeout", rootCmd.PersistentFlags().Lookup("request-timeout")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().DurationVar(&scanTimeout, "scan-timeout", 5*time.Minute, "timeout of a scan of all namespaces, 0 no timeout")
+	if err := viper.BindPFlag("app.scanTimeout", rootCmd.PersistentFlags().Lookup("scan-timeout")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().Float64Var(&qps, "qps", 50, "client queries per second to api server")
+	if err := viper.BindPFlag("client.qps", rootCmd.PersistentFlags().Lookup("qps")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().IntVar(&burst, "burst", 100, "client burst queries to api server")
+	if err := viper.BindPFlag("client.burst", rootCmd.PersistentFlags().Lookup("burst")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().DurationVar(&cliTimeout, "client-timeout", 0, "http timeout of every api server request including watches, 0 no timeout")
+	if err := viper.BindPFlag("client.timeout", rootCmd.PersistentFlags().Lookup("client-timeout")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 3, "retries of read requests failed by network errors, 429 or 5xx")
+	if err := viper.BindPFlag("client.retry.max", rootCmd.PersistentFlags().Lookup("retries")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
+	rootCmd.PersistentFlags().DurationVar(&backoff, "retry-backoff", 500*time.Millisecond, "initial retry backoff, doubled on each retry")
+	if err := viper.BindPFlag("client.retry.initialBackoff", rootCmd.PersistentFlags().Lookup("retry-backoff")); err != nil {
+		fmt.Printf("FATAIL: %s", err)
+		os.Exit(1)
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if err := config.ViperInit(runMode, "k8res"); err != nil {
-		fmt.Printf("ERROR: init config failed, %v", err)
+		fmt.Fprintf(os.Stderr, "ERROR: init config failed, %v\n", err)
 	}
 	log.Initialize() // need following config init
 	if err := config.Save(); err != nil {
-		fmt.Printf("ERROR: save curr config failed, %v", err)
+		fmt.Fprintf(os.Stderr, "ERROR: save curr config failed, %v\n", err)
 	}
 }
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"fmt"
 	"github.com/spf13/viper"
 	"k8res/pkg/logger"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/tools/clientcmd"
+	"net/http"
 	"os"
 	"strings"
 
@@ -12,7 +15,10 @@ import (
 	metrics "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
+const metricsGroupVersion = "metrics.k8s.io/v1beta1"
+
 type K8s struct {
+	Cluster       string // cluster name, empty for the default cluster
 	ClientSet     clientSet.Interface
 	MetricsClient *metrics.Clientset
 	RestConfig    *clientReset.Config
@@ -20,29 +26,59 @@ type K8s struct {
 	outOfCluster  bool   // out of cluster config
 }
 
-// New creates a new k8s client
+// New creates a new k8s client, exits if it can not be created
 // cluster - used for get kubeconfig. refer getRestConfig
 func New(cluster string) *K8s {
+	k, err := NewE(cluster)
+	if err != nil {
+		logger.Fatalf("%v", err)
+		return nil
+	}
+	return k
+}
+
+// NewE creates a new k8s client, returns an error if the cluster config or clients can not be created
+// cluster - used for get kubeconfig. refer getRestConfig
+func NewE(cluster string) (*K8s, error) {
 	var err error
-	k := K8s{}
+	k := K8s{Cluster: cluster}
 
 	k.RestConfig, err = k.getRestConfig(cluster)
 	if err != nil {
-		logger.Fatalf("get %s cluster config failed: %v", cluster, err)
-		return nil
+		return nil, fmt.Errorf("get %s cluster config failed: %w", cluster, err)
 	}
+	configureClient(k.RestConfig)
 	k.ClientSet, err = clientSet.NewForConfig(k.RestConfig)
 	if err != nil {
-		logger.Fatalf("can not create kubernetes clientSet: %v", err)
-		return nil
+		return nil, fmt.Errorf("can not create kubernetes clientSet: %w", err)
 	}
 
 	k.MetricsClient, err = metrics.NewForConfig(k.RestConfig)
 	if err != nil {
-		logger.Fatalf("can not create kubernetes metric clientSet: %v", err)
-		return nil
+		return nil, fmt.Errorf("can not create kubernetes metric clientSet: %w", err)
 	}
-	return &k
+	return &k, nil
+}
+
+// configureClient sets rate limit, timeout and retry of client.* config to rest config,
+// shared by ClientSet and MetricsClient
+func configureClient(config *clientReset.Config) {
+	if qps := viper.GetFloat64("client.qps"); qps > 0 {
+		config.QPS = float32(qps)
+	}
+	if burst := viper.GetInt("client.burst"); burst > 0 {
+		config.Burst = burst
+	}
+	config.Timeout = viper.GetDuration("client.timeout")
+	retry := RetryConfig{
+		MaxRetries:     viper.GetInt("client.retry.max"),
+		InitialBackoff: viper.GetDuration("client.retry.initialBackoff"),
+		MaxBackoff:     viper.GetDuration("client.retry.maxBackoff"),
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return newRetryTransport(rt, retry)
+	})
+	logger.Infof("client qps %v burst %d timeout %v retries %d", config.QPS, config.Burst, config.Timeout, retry.MaxRetries)
 }
 
 // GetVersion returns the version of the kubernetes cluster that is running
@@ -54,6 +90,19 @@ func (k *K8s) GetVersion() (string, error) {
 	return version.String(), nil
 }
 
+// MetricsAvailable reports whether metrics.k8s.io/v1beta1 is served by the cluster,
+// err is not nil if discovery failed for another reason than the group is missing
+func (k *K8s) MetricsAvailable() (bool, error) {
+	_, err := k.ClientSet.Discovery().ServerResourcesForGroupVersion(metricsGroupVersion)
+	if err == nil {
+		return true, nil
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 func (k *K8s) SetNamespace(namespace string) {
 	k.namespace = namespace
 }
@@ -92,10 +141,15 @@ func (k *K8s) GetCurNamespace() string {
 	return namespace
 }
 
-// getRestConfig will return a rest config for the kubernetes cluster
+// getRestConfig will return a rest config for the kubernetes cluster.
+// kubeconfig is clusters.<cluster>.kube-config, or <cluster>.kube-config, or the default kubeconfig,
+// with the clusters.<cluster>.context context or the current context of the kubeconfig
 func (k *K8s) getRestConfig(cluster string) (*clientReset.Config, error) {
 	k.outOfCluster = true
 	kubeconfigPath := viper.GetString(cluster + ".kube-config")
+	if path := viper.GetString("clusters." + cluster + ".kube-config"); cluster != "" && path != "" {
+		kubeconfigPath = path
+	}
 	if kubeconfigPath == "" {
 		kubeconfigPath = clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
 		if kubeconfigPath != "" {
@@ -110,5 +164,10 @@ func (k *K8s) getRestConfig(cluster string) (*clientReset.Config, error) {
 		return clientReset.InClusterConfig()
 	}
 	logger.Infof("use %s cluster out config %s", cluster, kubeconfigPath)
+	if context := viper.GetString("clusters." + cluster + ".context"); cluster != "" && context != "" {
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+			&clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+	}
 	return clientcmd.BuildConfigFromFlags("", kubeconfigPath)
 }
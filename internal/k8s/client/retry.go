@@ -0,0 +1,93 @@
+package client
+
+import (
+	"io"
+	"k8res/pkg/logger"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig retry of failed read requests with exponential backoff
+type RetryConfig struct {
+	MaxRetries     int           // 0 no retry
+	InitialBackoff time.Duration // doubled on each retry
+	MaxBackoff     time.Duration
+}
+
+// retryTransport retries GET requests failed by network errors, 429 or 5xx.
+// Other methods are never retried, they may not be idempotent.
+type retryTransport struct {
+	next http.RoundTripper
+	conf RetryConfig
+}
+
+func newRetryTransport(next http.RoundTripper, conf RetryConfig) http.RoundTripper {
+	if conf.MaxRetries <= 0 {
+		return next
+	}
+	if conf.InitialBackoff <= 0 {
+		conf.InitialBackoff = 500 * time.Millisecond
+	}
+	if conf.MaxBackoff < conf.InitialBackoff {
+		conf.MaxBackoff = conf.InitialBackoff
+	}
+	return &retryTransport{next: next, conf: conf}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+	backoff := t.conf.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.conf.MaxRetries || req.Context().Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		delay := wait.Jitter(backoff, 0.1)
+		if resp != nil {
+			if after := retryAfter(resp); after > 0 {
+				delay = after
+			}
+			logger.Debugf("retry %s in %v after status %d", req.URL.Path, delay, resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			logger.Debugf("retry %s in %v after error: %v", req.URL.Path, delay, err)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		if backoff *= 2; backoff > t.conf.MaxBackoff {
+			backoff = t.conf.MaxBackoff
+		}
+	}
+}
+
+// retryable reports whether a request failed by a transient error
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		_, isNetErr := err.(net.Error)
+		return isNetErr
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns Retry-After seconds of a response, 0 if not set
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
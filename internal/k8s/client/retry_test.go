@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"k8res/pkg/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// without log settings the logger writes nowhere
+	logger.Initialize()
+	os.Exit(m.Run())
+}
+
+// statusServer replies the statuses in order, the last one repeated, and records request times
+type statusServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string // Retry-After header of error replies
+	times      []time.Time
+}
+
+func newStatusServer(statuses ...int) *statusServer {
+	s := &statusServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		status := s.statuses[len(s.statuses)-1]
+		if len(s.times) < len(s.statuses) {
+			status = s.statuses[len(s.times)]
+		}
+		s.times = append(s.times, time.Now())
+		if status >= 400 && s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *statusServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.times)
+}
+
+func roundTrip(t *testing.T, ctx context.Context, conf RetryConfig, method, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newRetryTransport(http.DefaultTransport, conf).RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRetryStatuses(t *testing.T) {
+	conf := RetryConfig{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	tests := []struct {
+		name         string
+		method       string
+		statuses     []int
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"ok", http.MethodGet, []int{200}, 200, 1},
+		{"429 then ok", http.MethodGet, []int{429, 200}, 200, 2},
+		{"5xx then ok", http.MethodGet, []int{500, 502, 200}, 200, 3},
+		{"503 until retries are used up", http.MethodGet, []int{503}, 503, 3},
+		{"404 is not retried", http.MethodGet, []int{404, 200}, 404, 1},
+		{"post is not retried", http.MethodPost, []int{503, 200}, 503, 1},
+		{"put is not retried", http.MethodPut, []int{429, 200}, 429, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStatusServer(tt.statuses...)
+			defer s.Close()
+			resp, err := roundTrip(t, context.Background(), conf, tt.method, s.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus || s.attempts() != tt.wantAttempts {
+				t.Errorf("status %d after %d attempts, want %d after %d",
+					resp.StatusCode, s.attempts(), tt.wantStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryWithoutRetries(t *testing.T) {
+	if rt := newRetryTransport(http.DefaultTransport, RetryConfig{}); rt != http.DefaultTransport {
+		t.Errorf("transport without retries = %T, want the next transport", rt)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := newStatusServer(429, 200)
+	s.retryAfter = "1"
+	defer s.Close()
+	conf := RetryConfig{MaxRetries: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	if _, err := roundTrip(t, context.Background(), conf, http.MethodGet, s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if s.attempts() != 2 {
+		t.Fatalf("attempts = %d, want 2", s.attempts())
+	}
+	if gap := s.times[1].Sub(s.times[0]); gap < time.Second {
+		t.Errorf("retry after %v, want Retry-After 1s", gap)
+	}
+}
+
+func TestRetryBackoffDoublesUpToMax(t *testing.T) {
+	s := newStatusServer(503)
+	defer s.Close()
+	initial, max := 20*time.Millisecond, 40*time.Millisecond
+	conf := RetryConfig{MaxRetries: 4, InitialBackoff: initial, MaxBackoff: max}
+	if _, err := roundTrip(t, context.Background(), conf, http.MethodGet, s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if s.attempts() != 5 {
+		t.Fatalf("attempts = %d, want 5", s.attempts())
+	}
+	for i, want := range []time.Duration{initial, max, max, max} {
+		// backoff has up to 10% jitter
+		if gap := s.times[i+1].Sub(s.times[i]); gap < want || gap >= 2*want {
+			t.Errorf("retry %d after %v, want %v", i+1, gap, want)
+		}
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	s := newStatusServer(503)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conf := RetryConfig{MaxRetries: 5, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+	start := time.Now()
+	_, err := roundTrip(t, ctx, conf, http.MethodGet, s.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v, want at the context deadline", elapsed)
+	}
+	if s.attempts() != 1 {
+		t.Errorf("attempts = %d, want 1", s.attempts())
+	}
+}
@@ -0,0 +1,113 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"k8res/pkg/logger"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+	"sync"
+)
+
+// cacheGroup informers of one or more factories, started, synced and stopped together.
+// A forbidden or bad request list or watch fails the sync at once, reflectors would retry it forever.
+type cacheGroup struct {
+	name      string
+	factories []informers.SharedInformerFactory
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+	failed    chan error // first forbidden or bad request watch error
+}
+
+func newCacheGroup(name string, factories ...informers.SharedInformerFactory) *cacheGroup {
+	return &cacheGroup{
+		name:      name,
+		factories: factories,
+		stopCh:    make(chan struct{}),
+		failed:    make(chan error, 1),
+	}
+}
+
+// add registers an informer of the group factories, before start
+func (g *cacheGroup) add(informer cache.SharedIndexInformer) {
+	if err := informer.SetTransform(stripManagedFields); err != nil {
+		logger.Warnf("set informer transform failed: %v", err)
+	}
+	err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		if isForbidden(err) || isBadRequest(err) {
+			select {
+			case g.failed <- err:
+			default:
+			}
+		}
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+	if err != nil {
+		logger.Warnf("set informer watch error handler failed: %v", err)
+	}
+}
+
+// start starts informers and waits until caches are synced, ctx is done or a watch fails for good.
+// The group is stopped if a watch is forbidden or a bad request.
+func (g *cacheGroup) start(ctx context.Context) error {
+	for _, factory := range g.factories {
+		factory.Start(g.stopCh)
+	}
+	waitCh := make(chan struct{})
+	synced := make(chan error, 1)
+	go func() {
+		for _, factory := range g.factories {
+			for informerType, ok := range factory.WaitForCacheSync(waitCh) {
+				if !ok {
+					synced <- fmt.Errorf("sync %v cache of %s failed", informerType, g.name)
+					return
+				}
+			}
+		}
+		synced <- nil
+	}()
+	select {
+	case err := <-synced:
+		return err
+	case err := <-g.failed:
+		close(waitCh)
+		g.stop()
+		return fmt.Errorf("watch %s: %w", g.name, err)
+	case <-ctx.Done():
+		close(waitCh)
+		return fmt.Errorf("sync %s caches: %w", g.name, ctx.Err())
+	}
+}
+
+func (g *cacheGroup) stop() {
+	g.stopOnce.Do(func() { close(g.stopCh) })
+}
+
+// isForbidden reports whether a list or watch is denied by RBAC or authentication.
+// Reflectors format list errors with %v, so the status error is only found in the message.
+func isForbidden(err error) bool {
+	if errors.IsForbidden(err) || errors.IsUnauthorized(err) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, " is forbidden: ") || strings.Contains(msg, "Unauthorized")
+}
+
+// isBadRequest reports whether a list or watch is rejected by the server, e.g. for an unsupported field selector
+func isBadRequest(err error) bool {
+	if errors.IsBadRequest(err) || errors.IsInvalid(err) {
+		return true
+	}
+	return strings.Contains(err.Error(), "field label not supported")
+}
+
+// stripManagedFields drops managed fields of cached objects, they are large and never used
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
+}
@@ -0,0 +1,433 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	k8client "k8res/internal/k8s/client"
+	"k8res/pkg/config"
+	"k8res/pkg/logger"
+	"k8res/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Collector collects pod resources into a store.
+// Namespaces, pods, pvcs, pvs, replicasets and jobs are watched once by shared informers and read from the local cache,
+// only metrics are requested on each GetPodRes, with one list per namespace.
+// If app.namespaces are names, pods, pvcs, replicasets and jobs are watched in each of them only,
+// and namespaces are not watched. Pvs, replicasets and jobs are only watched if the export uses them.
+type Collector struct {
+	k8         *k8client.K8s
+	selector   string // pod label selector, also used by metrics list
+	namespaces *namespaceFilter
+	phases     map[corev1.PodPhase]bool // pod phases to collect, from app.phases
+	extended   *utils.Matcher           // extended resources allowlist
+
+	groups   []*cacheGroup                      // required caches of all namespaces, Start fails if one can not sync
+	caches   map[string]*namespaceCache         // [namespace] with exact names, [""] for all namespaces
+	nsLister corelisters.NamespaceLister        // nil with exact names
+	pvGroup  *cacheGroup                        // nil if pvs are not used
+	pvLister corelisters.PersistentVolumeLister // nil if pvs are not used or can not be watched
+
+	metricsAvailable   bool  // metrics.k8s.io is served, checked by Start
+	metricsOff         int32 // metrics are forbidden or unavailable, set for the session by the first scans
+	metricsUnavailable int32 // consecutive metrics requests failed with service unavailable
+	nodeStatsOff       int32 // node proxy is forbidden, no ephemeral usage
+}
+
+// namespaceCache caches of namespaced objects of one namespace, or of all namespaces
+type namespaceCache struct {
+	group      *cacheGroup // pods and pvcs
+	podLister  corelisters.PodLister
+	pvcLister  corelisters.PersistentVolumeClaimLister
+	err        error                        // pods of the namespace can not be watched
+	ownerGroup *cacheGroup                  // nil if owners are not used
+	rsLister   appslisters.ReplicaSetLister // nil if owners are not used or can not be watched
+	jobLister  batchlisters.JobLister       // nil if owners are not used or can not be watched
+}
+
+// NewCollector creates a collector of k8s cluster, call Start before GetPodRes.
+// Pods are selected by label selector app.selector and field selector app.fieldSelector,
+// namespaces by app.namespaces, app.excludeNamespaces and app.namespaceSelector, phases by app.phases.
+func NewCollector(k8 *k8client.K8s) (*Collector, error) {
+	selector := config.GetString("app.selector")
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+	fieldSelector := config.GetString("app.fieldSelector")
+	if err := checkPodFieldSelector(fieldSelector); err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %w", fieldSelector, err)
+	}
+	namespaces, err := newNamespaceFilter()
+	if err != nil {
+		return nil, err
+	}
+	phases, err := podPhases(config.GetStringSlice("app.phases"))
+	if err != nil {
+		return nil, err
+	}
+	extended, err := extendedResources()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Collector{
+		k8:         k8,
+		selector:   selector,
+		namespaces: namespaces,
+		phases:     phases,
+		extended:   extended,
+		caches:     make(map[string]*namespaceCache),
+	}
+	usesVolumes, usesOwners := exportUses()
+	if namespaces.names == nil {
+		factory := informers.NewSharedInformerFactory(k8.ClientSet, 0)
+		cluster := newCacheGroup("namespaces", factory)
+		c.nsLister = factory.Core().V1().Namespaces().Lister()
+		cluster.add(factory.Core().V1().Namespaces().Informer())
+		c.groups = append(c.groups, cluster)
+	}
+	if usesVolumes && config.GetBool("app.pvCapacity") {
+		pvFactory := informers.NewSharedInformerFactory(k8.ClientSet, 0)
+		c.pvGroup = newCacheGroup("persistentvolumes", pvFactory)
+		c.pvLister = pvFactory.Core().V1().PersistentVolumes().Lister()
+		c.pvGroup.add(pvFactory.Core().V1().PersistentVolumes().Informer())
+	}
+
+	if namespaces.names == nil {
+		c.caches[metav1.NamespaceAll] = newNamespaceCache(k8, metav1.NamespaceAll, selector, fieldSelector, usesOwners)
+		c.groups = append(c.groups, c.caches[metav1.NamespaceAll].group)
+	}
+	for _, ns := range namespaces.names {
+		c.caches[ns] = newNamespaceCache(k8, ns, selector, fieldSelector, usesOwners)
+	}
+	return c, nil
+}
+
+// newNamespaceCache registers informers of namespace, all namespaces if it is empty
+func newNamespaceCache(k8 *k8client.K8s, namespace, selector, fieldSelector string, usesOwners bool) *namespaceCache {
+	name := "namespace " + namespace
+	if namespace == metav1.NamespaceAll {
+		name = "all namespaces"
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(k8.ClientSet, 0, informers.WithNamespace(namespace))
+	podFactory := informers.NewSharedInformerFactoryWithOptions(k8.ClientSet, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+			options.FieldSelector = fieldSelector
+		}))
+	nc := &namespaceCache{
+		group:     newCacheGroup("pods of "+name, podFactory, factory),
+		podLister: podFactory.Core().V1().Pods().Lister(),
+		pvcLister: factory.Core().V1().PersistentVolumeClaims().Lister(),
+	}
+	nc.group.add(podFactory.Core().V1().Pods().Informer())
+	nc.group.add(factory.Core().V1().PersistentVolumeClaims().Informer())
+	if usesOwners && config.GetBool("app.ownerChain") {
+		ownerFactory := informers.NewSharedInformerFactoryWithOptions(k8.ClientSet, 0, informers.WithNamespace(namespace))
+		nc.ownerGroup = newCacheGroup("owners of "+name, ownerFactory)
+		nc.rsLister = ownerFactory.Apps().V1().ReplicaSets().Lister()
+		nc.jobLister = ownerFactory.Batch().V1().Jobs().Lister()
+		nc.ownerGroup.add(ownerFactory.Apps().V1().ReplicaSets().Informer())
+		nc.ownerGroup.add(ownerFactory.Batch().V1().Jobs().Informer())
+	}
+	return nc
+}
+
+// Start checks metrics api, starts informers and waits until caches are synced.
+// Without metrics api the collector runs in requests/limits only mode.
+// Caches of all namespaces must sync. Pods of a named namespace which can not be watched
+// are reported by each scan, pvs and owners which can not be watched are not used.
+func (c *Collector) Start(ctx context.Context) error {
+	start := time.Now()
+	c.metricsAvailable = metricsAvailable(ctx, c.k8)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(c.groups))
+	for i, group := range c.groups {
+		wg.Add(1)
+		go func(i int, group *cacheGroup) {
+			defer wg.Done()
+			errs[i] = group.start(ctx)
+		}(i, group)
+	}
+	if c.pvGroup != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.pvGroup.start(ctx); err != nil {
+				logger.Warnf("pv capacity is not collected: %v", err)
+				c.pvLister = nil
+			}
+		}()
+	}
+	for ns, nc := range c.caches {
+		wg.Add(1)
+		go func(ns string, nc *namespaceCache) {
+			defer wg.Done()
+			if ns != metav1.NamespaceAll {
+				nc.err = nc.group.start(ctx)
+			}
+			if nc.ownerGroup == nil {
+				return
+			}
+			if err := nc.ownerGroup.start(ctx); err != nil {
+				logger.Warnf("workloads are resolved by pod-template-hash only: %v", err)
+				nc.rsLister, nc.jobLister = nil, nil
+			}
+		}(ns, nc)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	logger.Infof("informer caches synced in %v", time.Since(start))
+	return nil
+}
+
+// MetricsAvailable reports whether usage is collected from metrics api
+func (c *Collector) MetricsAvailable() bool {
+	return c.metricsAvailable && atomic.LoadInt32(&c.metricsOff) == 0
+}
+
+// metrics api discovery attempts and the backoff between them
+const (
+	metricsDiscoveryAttempts = 3
+	metricsDiscoveryBackoff  = time.Second
+)
+
+// metricsAvailable checks whether metrics api is served by the cluster. A missing, forbidden or
+// unavailable api turns usage off, if discovery keeps failing for another reason usage stays on
+// and metrics errors are reported by each scan.
+func metricsAvailable(ctx context.Context, k8 *k8client.K8s) bool {
+	available, err := k8.MetricsAvailable()
+	for attempt := 1; err != nil && attempt < metricsDiscoveryAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(metricsDiscoveryBackoff * time.Duration(attempt)):
+		}
+		available, err = k8.MetricsAvailable()
+	}
+	switch {
+	case err != nil && (isForbidden(err) || errors.IsServiceUnavailable(err)):
+		logger.Warnf("metrics api can not be used, collect requests and limits only: %v", err)
+		return false
+	case err != nil:
+		logger.Warnf("discover metrics api failed, collect usage anyway: %v", err)
+		return true
+	case !available:
+		logger.Warn("metrics api is not available, collect requests and limits only")
+	}
+	return available
+}
+
+// Stop stops informers
+func (c *Collector) Stop() {
+	c.allGroups(func(group *cacheGroup) { group.stop() })
+}
+
+func (c *Collector) allGroups(fn func(group *cacheGroup)) {
+	for _, group := range c.groups {
+		fn(group)
+	}
+	if c.pvGroup != nil {
+		fn(c.pvGroup)
+	}
+	for _, nc := range c.caches {
+		fn(nc.group)
+		if nc.ownerGroup != nil {
+			fn(nc.ownerGroup)
+		}
+	}
+}
+
+// cache returns caches of namespace
+func (c *Collector) cache(namespace string) *namespaceCache {
+	if nc, ok := c.caches[namespace]; ok {
+		return nc
+	}
+	return c.caches[metav1.NamespaceAll]
+}
+
+// GetPodRes samples requests, limits and usage of running pods into store,
+// namespaces are collected concurrently by app.workers workers.
+// Failures of a namespace or a pod don't stop the scan, they are added to the report errors,
+// an error is only returned if nothing can be scanned or ctx is done.
+// The scan stops at app.scanTimeout, each request at app.requestTimeout.
+func (c *Collector) GetPodRes(ctx context.Context, store *AllPodResStore) (*ScanReport, error) {
+	report := &ScanReport{Cluster: c.k8.Cluster, Start: time.Now()}
+	scanCtx, cancel := withTimeout(ctx, config.GetDuration("app.scanTimeout"))
+	defer cancel()
+	s := &scan{
+		ctx:            scanCtx,
+		now:            report.Start,
+		requestTimeout: config.GetDuration("app.requestTimeout"),
+		extended:       c.extended,
+		report:         report,
+	}
+	if config.GetBool("app.ephemeralUsage") {
+		s.nodeStats = newNodeStatsCache(s, c.k8, &c.nodeStatsOff)
+	}
+
+	usedNamespaces := c.namespaces.names
+	if c.nsLister != nil {
+		allNamespaces, err := c.nsLister.List(labels.Everything())
+		if err != nil {
+			return report, err
+		}
+		usedNamespaces = c.namespaces.filter(allNamespaces)
+	}
+	report.Namespaces = len(usedNamespaces)
+
+	workers := config.GetInt("app.workers")
+	if workers <= 0 {
+		workers = 1
+	}
+	nsCh := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failedNamespaces := make(map[string]bool)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range nsCh {
+				if s.ctx.Err() != nil {
+					continue
+				}
+				if !c.collectNamespace(s, store, ns) {
+					mu.Lock()
+					failedNamespaces[ns] = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, ns := range usedNamespaces {
+		nsCh <- ns
+	}
+	close(nsCh)
+	wg.Wait()
+
+	report.Duration = time.Since(s.now)
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+	if s.ctx.Err() != nil {
+		report.addError("", "", StepScan, fmt.Errorf("timeout after %v, pods not scanned keep their last values", report.Duration))
+		return report, nil
+	}
+	// pods of failed namespaces are unknown, not gone
+	store.MarkGone(failedNamespaces, s.now)
+	logger.Infof("scan %s cluster %d namespaces %d pods (%d unscheduled) in %v with %d errors",
+		report.Cluster, report.Namespaces, report.Pods, report.Unscheduled, report.Duration, len(report.Errors))
+	return report, nil
+}
+
+// withTimeout returns a child context of ctx with timeout, without timeout if it is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// collectNamespace collects pods of a namespace in selected phases, returns false if pods of the namespace can not be listed
+func (c *Collector) collectNamespace(s *scan, store *AllPodResStore, ns string) bool {
+	nc := c.cache(ns)
+	if nc.err != nil {
+		s.addError(ns, "", StepPods, nc.err)
+		return false
+	}
+	pods, err := nc.podLister.Pods(ns).List(labels.Everything())
+	if err != nil {
+		s.addError(ns, "", StepPods, err)
+		return false
+	}
+	if len(pods) == 0 {
+		return true
+	}
+	var metrics *namespaceMetrics
+	if c.MetricsAvailable() {
+		metrics = c.listNamespaceMetrics(s, ns)
+	}
+	n := 0
+	for _, pod := range pods {
+		if s.ctx.Err() != nil {
+			break
+		}
+		if !c.phases[pod.Status.Phase] {
+			logger.Debugf("pod %s is %s, skipped", pod.Name, pod.Status.Phase)
+			continue
+		}
+		podStore := store.GetOrCreate(pod.Namespace, pod.Name)
+		if stats := store.takeLoadedStats(pod.Namespace, pod.Name); stats != nil {
+			if err := podStore.UsageStats.Merge(stats); err != nil {
+				logger.Warnf("merge loaded usage stats of %s/%s failed: %v", pod.Namespace, pod.Name, err)
+			}
+		}
+		c.collectPod(s, podStore, pod, metrics)
+		n++
+	}
+	s.report.addPods(n)
+	return true
+}
+
+// podFieldLabels pod fields the api server supports in field selectors
+var podFieldLabels = map[string]bool{
+	"metadata.name": true, "metadata.namespace": true,
+	"spec.nodeName": true, "spec.restartPolicy": true, "spec.schedulerName": true,
+	"spec.serviceAccountName": true, "spec.hostNetwork": true,
+	"status.phase": true, "status.podIP": true, "status.podIPs": true, "status.nominatedNodeName": true,
+}
+
+// checkPodFieldSelector checks syntax and fields of a pod field selector,
+// the pod list would fail with bad request on every retry otherwise
+func checkPodFieldSelector(selector string) error {
+	parsed, err := fields.ParseSelector(selector)
+	if err != nil {
+		return err
+	}
+	for _, r := range parsed.Requirements() {
+		if !podFieldLabels[r.Field] {
+			return fmt.Errorf("field %q is not supported for pods", r.Field)
+		}
+	}
+	return nil
+}
+
+// podPhases parses pod phase names, case insensitive, "all" is every phase, empty is Running
+func podPhases(names []string) (map[corev1.PodPhase]bool, error) {
+	all := []corev1.PodPhase{corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown}
+	if len(names) == 0 {
+		names = []string{string(corev1.PodRunning)}
+	}
+	phases := make(map[corev1.PodPhase]bool)
+	for _, name := range names {
+		found := false
+		for _, phase := range all {
+			if strings.EqualFold(name, "all") || strings.EqualFold(name, string(phase)) {
+				phases[phase] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pod phase %q", name)
+		}
+	}
+	return phases, nil
+}
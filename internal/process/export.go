@@ -0,0 +1,529 @@
+package process
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"k8res/pkg/config"
+	"k8res/pkg/logger"
+	"k8res/pkg/sketch"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// export levels, set by app.level
+const (
+	LevelPod       = "pod"
+	LevelContainer = "container"
+	LevelWorkload  = "workload"
+)
+
+// export formats, set by app.output
+const (
+	OutputText = "text"
+	OutputCSV  = "csv"
+	OutputJSON = "json"
+)
+
+// usageQuantiles quantiles of usage samples in pod level export
+var usageQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// row export fields, string, bool, integer, float64 or time.Time
+type row []interface{}
+
+// table export rows with header, all export formats are written from it
+type table struct {
+	header []string
+	rows   []row
+}
+
+func (t *table) addRow(fields row) {
+	t.rows = append(t.rows, fields)
+}
+
+// CheckExportOptions checks app.level, app.output and app.groupBy, so a bad option fails before a scan
+func CheckExportOptions() error {
+	switch level := config.GetString("app.level"); level {
+	case LevelPod, LevelContainer, LevelWorkload:
+	default:
+		return fmt.Errorf("unknown export level %q, use %s, %s or %s", level, LevelPod, LevelContainer, LevelWorkload)
+	}
+	switch output := config.GetString("app.output"); output {
+	case OutputText, OutputCSV, OutputJSON:
+	default:
+		return fmt.Errorf("unknown output format %q, use %s, %s or %s", output, OutputText, OutputCSV, OutputJSON)
+	}
+	_, err := ParseGroupBy(config.GetStringSlice("app.groupBy"))
+	return err
+}
+
+// exportUses reports whether the export of app.level and app.groupBy shows pv capacity and workloads,
+// so the collector only watches pvs, replicasets and jobs if they are needed
+func exportUses() (volumes, workloads bool) {
+	if len(config.GetStringSlice("app.groupBy")) > 0 {
+		return false, false
+	}
+	level := config.GetString("app.level")
+	return level == LevelPod, level != LevelContainer
+}
+
+// ClusterStore pod resources of one cluster, Cluster is empty for the default cluster
+type ClusterStore struct {
+	Cluster string
+	Store   *AllPodResStore
+}
+
+// ExportClusterPodRes prints records of all clusters combined in one table, with a leading cluster column
+// unless there is only the default cluster. One line each pod, container or workload with app.level,
+// or each group of label values with app.groupBy, in app.output format.
+// With app.totals, a second table of namespace subtotals and the cluster total follows,
+// in json output both are in one object {"rows": [...], "totals": [...]}.
+func ExportClusterPodRes(clusters []ClusterStore) {
+	groupBy, err := ParseGroupBy(config.GetStringSlice("app.groupBy"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return
+	}
+	level, output, totals := config.GetString("app.level"), config.GetString("app.output"), config.GetBool("app.totals")
+	names := make([]string, 0, len(clusters))
+	var rowTables, totalTables []*table
+	for _, c := range clusters {
+		names = append(names, c.Cluster)
+		switch {
+		case len(groupBy) > 0:
+			rowTables = append(rowTables, groupTable(c.Store.All(), groupBy))
+		case level == LevelWorkload:
+			rowTables = append(rowTables, workloadResTable(c.Store.Workloads()))
+		default:
+			rowTables = append(rowTables, podResTable(c.Store, level))
+		}
+		if totals {
+			totalTables = append(totalTables, totalsTable(c.Store.All()))
+		}
+	}
+	switch {
+	case totals && output == OutputJSON:
+		// one json document, two arrays in a row are not valid json
+		err = writeJSON(map[string]interface{}{
+			"rows":   jsonObjects(mergeClusterTables(names, rowTables)),
+			"totals": jsonObjects(mergeClusterTables(names, totalTables)),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: write %s output failed, %v\n", output, err)
+		}
+	case totals:
+		writeTable(mergeClusterTables(names, rowTables), output)
+		writeTable(mergeClusterTables(names, totalTables), output)
+	default:
+		writeTable(mergeClusterTables(names, rowTables), output)
+	}
+}
+
+// mergeClusterTables combines tables of clusters with a cluster column, headers are joined
+// since extended resource columns differ between clusters, missing fields are 0
+func mergeClusterTables(clusters []string, tables []*table) *table {
+	if len(tables) == 1 && clusters[0] == "" {
+		return tables[0]
+	}
+	t := &table{header: []string{"cluster"}}
+	index := make(map[string]int)
+	for _, ct := range tables {
+		for _, name := range ct.header {
+			if _, ok := index[name]; !ok {
+				index[name] = len(t.header)
+				t.header = append(t.header, name)
+			}
+		}
+	}
+	for i, ct := range tables {
+		for _, r := range ct.rows {
+			merged := make(row, len(t.header))
+			merged[0] = clusters[i]
+			for j, name := range ct.header {
+				merged[index[name]] = r[j]
+			}
+			for j := range merged {
+				if merged[j] == nil {
+					merged[j] = int64(0)
+				}
+			}
+			t.addRow(merged)
+		}
+	}
+	return t
+}
+
+// workloadResTable builds export table of workloads, totals of all replicas and per replica averages
+func workloadResTable(workloads []*WorkloadRes) *table {
+	var resources []*Resources
+	for _, w := range workloads {
+		resources = append(resources, &w.Requests, &w.Limits)
+	}
+	extended := extendedNames(resources)
+	t := &table{header: append([]string{"namespace", "kind", "workload", "replicas"}, resHeader(extended)...)}
+	t.header = append(t.header, "replica_request_cpu", "replica_request_mem", "replica_limit_cpu", "replica_limit_mem",
+		"replica_usage_cpu", "replica_usage_mem")
+	for _, w := range workloads {
+		fields := append(row{w.Namespace, w.Kind, w.Name, w.Replicas}, resFields(&w.Requests, &w.Limits, &w.Usage, extended)...)
+		t.addRow(append(fields, intFields(
+			w.Requests.CPU.Current/w.Replicas, w.Requests.Mem.Current/w.Replicas,
+			w.Limits.CPU.Current/w.Replicas, w.Limits.Mem.Current/w.Replicas,
+			w.Usage.CPU.Current/w.Replicas, w.Usage.Mem.Current/w.Replicas)...))
+	}
+	return t
+}
+
+// ExportNodeRes prints one line each node in app.output format
+func ExportNodeRes(nodes []*NodeRes) {
+	writeTable(nodeResTable(nodes), config.GetString("app.output"))
+}
+
+// nodeResTable builds export table of nodes, requests, limits and usage with percentages of allocatable,
+// extended resources have no usage
+func nodeResTable(nodes []*NodeRes) *table {
+	var resources []*Resources
+	for _, n := range nodes {
+		resources = append(resources, &n.Allocatable, &n.Requests, &n.Limits)
+	}
+	extended := extendedNames(resources)
+	t := &table{header: []string{"node"}}
+	for _, kind := range []ResKind{ResCPU, ResMem, ResEphemeral} {
+		name := string(kind)
+		t.header = append(t.header, name+"_allocatable", name+"_request", name+"_request_pct",
+			name+"_limit", name+"_limit_pct", name+"_usage", name+"_usage_pct")
+	}
+	for _, name := range extended {
+		t.header = append(t.header, name+"_allocatable", name+"_request", name+"_request_pct",
+			name+"_limit", name+"_limit_pct")
+	}
+	t.header = append(t.header, "pods_allocatable", "pods", "pods_pct")
+	for _, n := range nodes {
+		fields := row{n.Name}
+		for _, kind := range []ResKind{ResCPU, ResMem, ResEphemeral} {
+			name := string(kind)
+			allocatable := current(&n.Allocatable, name)
+			requests, limits, usage := current(&n.Requests, name), current(&n.Limits, name), current(&n.Usage, name)
+			fields = append(fields, allocatable, requests, percent(requests, allocatable),
+				limits, percent(limits, allocatable), usage, percent(usage, allocatable))
+		}
+		for _, name := range extended {
+			allocatable := current(&n.Allocatable, name)
+			requests, limits := current(&n.Requests, name), current(&n.Limits, name)
+			fields = append(fields, allocatable, requests, percent(requests, allocatable),
+				limits, percent(limits, allocatable))
+		}
+		t.addRow(append(fields, n.MaxPods, n.Pods, percent(n.Pods, n.MaxPods)))
+	}
+	return t
+}
+
+// percent returns v in percent of total, 0 if total is 0
+func percent(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) * 100 / float64(total)
+}
+
+// podResTable builds export table of store with level
+func podResTable(store *AllPodResStore, level string) *table {
+	t := &table{}
+	pods := exportPods(store, config.GetBool("app.collapseReplaced"))
+	var resources []*Resources
+	for _, pod := range pods {
+		resources = append(resources, &pod.Requests, &pod.Limits)
+	}
+	extended := extendedNames(resources)
+	switch level {
+	case LevelContainer:
+		t.header = append([]string{"namespace", "pod", "container", "init"}, resHeader(extended)...)
+		t.header = append(t.header, "restarts", "last_termination", "last_termination_time")
+		for _, pod := range pods {
+			for _, c := range pod.Containers {
+				fields := append(row{pod.Namespace, pod.Name, c.Name, c.Init}, resFields(&c.Requests, &c.Limits, &c.Usage, extended)...)
+				t.addRow(append(fields, c.Restarts, c.LastTermination.Reason, c.LastTermination.Time))
+			}
+		}
+	default:
+		t.header = append([]string{"namespace", "pod"}, resHeader(extended)...)
+		t.header = append(t.header, appHeader()...)
+		t.header = append(t.header, "disk_capacity", "storage_classes")
+		t.header = append(t.header, statsHeader()...)
+		t.header = append(t.header, lifecycleHeader()...)
+		for _, pod := range pods {
+			fields := append(row{pod.Namespace, pod.Name}, resFields(&pod.Requests, &pod.Limits, &pod.Usage, extended)...)
+			fields = append(fields, appFields(pod)...)
+			fields = append(fields, pod.DiskCapacity(), strings.Join(pod.StorageClasses(), ";"))
+			fields = append(fields, statsFields(&pod.UsageStats)...)
+			t.addRow(append(fields, lifecycleFields(pod)...))
+		}
+	}
+	return t
+}
+
+// exportPods returns all pods of store. If collapse is set, gone pods replaced by a live pod
+// of the same workload are folded into one replaced row each workload, see foldReplaced.
+func exportPods(store *AllPodResStore, collapse bool) []*PodRes {
+	pods := store.All()
+	if !collapse {
+		return pods
+	}
+	live := make(map[string]bool)
+	for _, pod := range pods {
+		if !pod.Gone && pod.Workload != "" {
+			live[pod.Namespace+"/"+pod.Workload] = true
+		}
+	}
+	var exported []*PodRes
+	replaced := make(map[string][]*PodRes)
+	var keys []string
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Workload
+		if !pod.Gone || !live[key] {
+			exported = append(exported, pod)
+			continue
+		}
+		if _, ok := replaced[key]; !ok {
+			keys = append(keys, key)
+		}
+		replaced[key] = append(replaced[key], pod)
+	}
+	for _, key := range keys {
+		exported = append(exported, foldReplaced(replaced[key]))
+	}
+	sort.SliceStable(exported, func(i, j int) bool {
+		if exported[i].Namespace != exported[j].Namespace {
+			return exported[i].Namespace < exported[j].Namespace
+		}
+		return exported[i].Name < exported[j].Name
+	})
+	return exported
+}
+
+// foldReplaced folds gone pods of a workload into one gone record named "<workload> replaced".
+// Requests, limits, current usage and containers are of the last ended pod, usage min/max
+// and statistics cover all pods, seen times span all pods, samples and recreations add up.
+func foldReplaced(pods []*PodRes) *PodRes {
+	last := pods[0]
+	for _, pod := range pods[1:] {
+		if pod.EndTime.After(last.EndTime) {
+			last = pod
+		}
+	}
+	folded := *last
+	folded.Name = last.Workload + " replaced"
+	folded.Usage = Resources{}
+	folded.Usage.AddAll(&last.Usage)
+	folded.UsageStats = PodUsageStats{}
+	folded.Recreations, folded.Samples = 0, 0
+	for _, pod := range pods {
+		folded.Usage.MergeMinMax(&pod.Usage)
+		if err := folded.UsageStats.Merge(&pod.UsageStats); err != nil {
+			logger.Warnf("fold usage stats of %s/%s failed: %v", pod.Namespace, pod.Name, err)
+		}
+		if pod.FirstSeen.Before(folded.FirstSeen) {
+			folded.FirstSeen = pod.FirstSeen
+		}
+		if pod.LastSeen.After(folded.LastSeen) {
+			folded.LastSeen = pod.LastSeen
+		}
+		folded.Samples += pod.Samples
+		folded.Recreations += pod.Recreations
+	}
+	return &folded
+}
+
+// extendedNames sorted extended resource names of any of resources
+func extendedNames(resources []*Resources) []string {
+	set := make(map[string]bool)
+	for _, r := range resources {
+		for _, name := range r.ExtendedNames() {
+			set[name] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+// resHeader column names of resFields
+func resHeader(extended []string) []string {
+	var header []string
+	for _, prefix := range []string{"request_", "limit_"} {
+		for _, kind := range ResKinds {
+			header = append(header, prefix+string(kind))
+		}
+	}
+	for _, kind := range ResKinds {
+		header = append(header, "usage_"+string(kind)+"_min", "usage_"+string(kind), "usage_"+string(kind)+"_max")
+	}
+	for _, name := range extended {
+		header = append(header, "request_"+name, "limit_"+name)
+	}
+	return header
+}
+
+// resFields request, limit and usage columns of a record, followed by extended request and limit
+func resFields(requests, limits, usage *Resources, extended []string) row {
+	var values []int64
+	for _, r := range []*Resources{requests, limits} {
+		for _, kind := range ResKinds {
+			values = append(values, r.Get(kind).Current)
+		}
+	}
+	for _, kind := range ResKinds {
+		v := usage.Get(kind)
+		values = append(values, v.Min, v.Current, v.Max)
+	}
+	fields := intFields(values...)
+	for _, name := range extended {
+		fields = append(fields, intFields(current(requests, name), current(limits, name))...)
+	}
+	return fields
+}
+
+// current returns current value of an extended resource, 0 if not exist
+func current(r *Resources, name string) int64 {
+	if v := r.Get(ResKind(name)); v != nil {
+		return v.Current
+	}
+	return 0
+}
+
+// appHeader column names of appFields
+func appHeader() []string {
+	return []string{"app_request_cpu", "app_request_mem", "app_limit_cpu", "app_limit_mem"}
+}
+
+// appFields app containers only requests and limits of a pod, request/limit columns are effective values
+func appFields(pod *PodRes) row {
+	return intFields(pod.AppRequests.CPU.Current, pod.AppRequests.Mem.Current,
+		pod.AppLimits.CPU.Current, pod.AppLimits.Mem.Current)
+}
+
+// statsHeader column names of statsFields
+func statsHeader() []string {
+	var header []string
+	for _, kind := range []ResKind{ResCPU, ResMem} {
+		prefix := "usage_" + string(kind) + "_"
+		header = append(header, prefix+"samples", prefix+"mean")
+		for _, q := range usageQuantiles {
+			header = append(header, prefix+"p"+strconv.FormatFloat(q*100, 'f', -1, 64))
+		}
+	}
+	return header
+}
+
+// statsFields sample count, mean and quantiles of cpu and mem usage
+func statsFields(stats *PodUsageStats) row {
+	var fields row
+	for _, s := range []*sketch.Sketch{&stats.CPU, &stats.Mem} {
+		fields = append(fields, s.Count, s.Mean())
+		for _, q := range usageQuantiles {
+			fields = append(fields, s.Quantile(q))
+		}
+	}
+	return fields
+}
+
+// lifecycleHeader column names of lifecycleFields
+func lifecycleHeader() []string {
+	return []string{"workload", "node", "qos", "phase", "scheduling", "scheduling_message", "first_seen", "last_seen", "samples", "recreations",
+		"restarts", "last_termination", "last_termination_time", "gone", "end_time"}
+}
+
+// lifecycleFields workload, seen times, node, qos, phase, scheduling, restarts, last termination and gone state of a pod
+func lifecycleFields(pod *PodRes) row {
+	last := pod.LastTermination()
+	return row{pod.Workload, pod.NodeName, pod.QOSClass, pod.Phase, pod.Scheduling, pod.SchedulingMessage, pod.FirstSeen, pod.LastSeen, pod.Samples, pod.Recreations,
+		pod.Restarts(), last.Reason, last.Time, pod.Gone, pod.EndTime}
+}
+
+// intFields int64 values as row fields
+func intFields(values ...int64) row {
+	fields := make(row, 0, len(values))
+	for _, v := range values {
+		fields = append(fields, v)
+	}
+	return fields
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeTable prints table in output format
+func writeTable(t *table, output string) {
+	var err error
+	switch output {
+	case OutputCSV:
+		w := csv.NewWriter(os.Stdout)
+		if err = w.Write(t.header); err != nil {
+			break
+		}
+		for _, r := range t.rows {
+			if err = w.Write(formatRow(r)); err != nil {
+				break
+			}
+		}
+		w.Flush()
+		err = w.Error()
+	case OutputJSON:
+		err = writeJSON(jsonObjects(t))
+	default:
+		fmt.Println()
+		fmt.Println(strings.Join(t.header, ", "))
+		for _, r := range t.rows {
+			fmt.Println(strings.Join(formatRow(r), ", "))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: write %s output failed, %v\n", output, err)
+	}
+}
+
+// jsonObjects returns rows of table as objects keyed by header
+func jsonObjects(t *table) []map[string]interface{} {
+	objects := make([]map[string]interface{}, 0, len(t.rows))
+	for _, r := range t.rows {
+		object := make(map[string]interface{}, len(t.header))
+		for i, name := range t.header {
+			object[name] = r[i]
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// writeJSON prints v as one indented json document
+func writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// formatRow formats fields as strings, floats with 2 decimals, times in RFC3339 or empty if zero
+func formatRow(r row) []string {
+	fields := make([]string, 0, len(r))
+	for _, v := range r {
+		switch value := v.(type) {
+		case float64:
+			fields = append(fields, strconv.FormatFloat(value, 'f', 2, 64))
+		case time.Time:
+			if value.IsZero() {
+				fields = append(fields, "")
+			} else {
+				fields = append(fields, value.Format(time.RFC3339))
+			}
+		default:
+			fields = append(fields, fmt.Sprint(value))
+		}
+	}
+	return fields
+}
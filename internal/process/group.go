@@ -0,0 +1,71 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// groupLabelPrefix prefix of a label group-by key in app.groupBy
+const groupLabelPrefix = "label:"
+
+// unlabeled group value of pods without the label
+const unlabeled = "unlabeled"
+
+// ParseGroupBy returns label keys of app.groupBy specs "label:<key>"
+func ParseGroupBy(specs []string) ([]string, error) {
+	keys := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		key := strings.TrimPrefix(spec, groupLabelPrefix)
+		if key == spec || key == "" {
+			return nil, fmt.Errorf("invalid group by %q, use label:<key>", spec)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// groupTable builds one row each combination of label values of live pods, sorted by values.
+// Pods without a label are in the "unlabeled" group of the label.
+func groupTable(pods []*PodRes, keys []string) *table {
+	t := &table{}
+	for _, key := range keys {
+		t.header = append(t.header, "label_"+key)
+	}
+	extended := liveExtendedNames(pods)
+	t.header = append(t.header, totalHeader(extended)...)
+	groups := make(map[string]*resTotal)
+	values := make(map[string][]string)
+	for _, pod := range pods {
+		if pod.Gone {
+			continue
+		}
+		podValues := make([]string, 0, len(keys))
+		for _, key := range keys {
+			value, ok := pod.Labels[key]
+			if !ok {
+				value = unlabeled
+			}
+			podValues = append(podValues, value)
+		}
+		groupKey := strings.Join(podValues, "\x00")
+		if _, ok := groups[groupKey]; !ok {
+			groups[groupKey] = &resTotal{}
+			values[groupKey] = podValues
+		}
+		groups[groupKey].add(pod)
+	}
+	groupKeys := make([]string, 0, len(groups))
+	for groupKey := range groups {
+		groupKeys = append(groupKeys, groupKey)
+	}
+	sort.Strings(groupKeys)
+	for _, groupKey := range groupKeys {
+		fields := make(row, 0, len(t.header))
+		for _, value := range values[groupKey] {
+			fields = append(fields, value)
+		}
+		t.addRow(append(fields, groups[groupKey].fields(extended)...))
+	}
+	return t
+}
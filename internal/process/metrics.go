@@ -0,0 +1,93 @@
+package process
+
+import (
+	"fmt"
+	"k8res/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"os"
+	"sync/atomic"
+)
+
+// metricsUnavailableLimit consecutive service unavailable metrics requests which turn usage off
+const metricsUnavailableLimit = 3
+
+// namespaceMetrics pod metrics of a namespace, listed once per scan with the pod label selector
+// and joined to the selected pods by name. If the list is forbidden by RBAC, metrics are got pod by pod.
+type namespaceMetrics struct {
+	namespace string
+	perPod    bool
+	pods      map[string]*metricsv1beta1.PodMetrics
+}
+
+// listNamespaceMetrics lists metrics of all pods in namespace, pods have no usage if the list failed
+func (c *Collector) listNamespaceMetrics(s *scan, namespace string) *namespaceMetrics {
+	m := &namespaceMetrics{namespace: namespace}
+	ctx, cancel := s.requestCtx()
+	defer cancel()
+	list, err := c.k8.MetricsClient.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector})
+	if errors.IsForbidden(err) {
+		logger.Warnf("list pod metrics of namespace %s is forbidden, get metrics pod by pod", namespace)
+		m.perPod = true
+		return m
+	}
+	if err != nil {
+		if !c.metricsFailed(err) {
+			s.addError(namespace, "", StepMetrics, err)
+		}
+		return m
+	}
+	atomic.StoreInt32(&c.metricsUnavailable, 0)
+	m.pods = make(map[string]*metricsv1beta1.PodMetrics, len(list.Items))
+	for i := range list.Items {
+		m.pods[list.Items[i].Name] = &list.Items[i]
+	}
+	return m
+}
+
+// podMetrics returns metrics of a pod, nil if metrics of the pod are not available yet
+// or m is nil without metrics api
+func (c *Collector) podMetrics(s *scan, m *namespaceMetrics, pod *corev1.Pod) (*metricsv1beta1.PodMetrics, error) {
+	if m == nil || atomic.LoadInt32(&c.metricsOff) != 0 {
+		return nil, nil
+	}
+	if !m.perPod {
+		return m.pods[pod.Name], nil
+	}
+	ctx, cancel := s.requestCtx()
+	defer cancel()
+	mc := c.k8.MetricsClient.MetricsV1beta1().PodMetricses(pod.Namespace)
+	podMetrics, err := mc.Get(ctx, pod.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// new pods have no metrics until the first metrics-server scrape
+		return nil, nil
+	}
+	if err != nil {
+		if c.metricsFailed(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	atomic.StoreInt32(&c.metricsUnavailable, 0)
+	return podMetrics, nil
+}
+
+// metricsFailed turns usage off for the session of the collector if metrics are forbidden, or unavailable
+// metricsUnavailableLimit times in a row, with one notice. It reports whether usage is off.
+func (c *Collector) metricsFailed(err error) bool {
+	if !isForbidden(err) &&
+		!(errors.IsServiceUnavailable(err) && atomic.AddInt32(&c.metricsUnavailable, 1) >= metricsUnavailableLimit) {
+		return atomic.LoadInt32(&c.metricsOff) != 0
+	}
+	if atomic.CompareAndSwapInt32(&c.metricsOff, 0, 1) {
+		logger.Warnf("get pod metrics failed, collect requests and limits only: %v", err)
+		prefix := ""
+		if c.k8.Cluster != "" {
+			prefix = "cluster " + c.k8.Cluster + ": "
+		}
+		fmt.Fprintf(os.Stderr, "NOTICE: %smetrics.k8s.io api can not be used, only requests and limits are reported from now on\n", prefix)
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package process
+
+import (
+	"fmt"
+	"k8res/pkg/config"
+	"k8res/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strings"
+)
+
+// allNamespaces namespace pattern of all namespaces
+const allNamespaces = "all"
+
+// groupPrefix prefix of a namespace group name in namespace patterns, groups are in app.namespaceGroups
+const groupPrefix = "@"
+
+// namespaceFilter selects namespaces to scan by
+// app.namespaces, app.excludeNamespaces and app.namespaceSelector.
+// Patterns are names, globs ("kube-*"), regular expressions ("re:^team-(a|b)$")
+// or "@group" of app.namespaceGroups.
+type namespaceFilter struct {
+	all      bool // no include patterns or "all"
+	include  *utils.Matcher
+	exclude  *utils.Matcher
+	selector labels.Selector
+	names    []string // sorted selected namespaces if all include patterns are names and there is no selector
+}
+
+// newNamespaceFilter creates a filter from config
+func newNamespaceFilter() (*namespaceFilter, error) {
+	groups := config.GetStringMapStringSlice("app.namespaceGroups")
+	include, err := expandGroups(config.GetStringSlice("app.namespaces"), groups)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := expandGroups(config.GetStringSlice("app.excludeNamespaces"), groups)
+	if err != nil {
+		return nil, err
+	}
+	f := &namespaceFilter{all: len(include) == 0}
+	for _, pattern := range include {
+		if pattern == allNamespaces {
+			f.all = true
+		}
+	}
+	if f.include, err = utils.NewMatcher(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = utils.NewMatcher(exclude); err != nil {
+		return nil, err
+	}
+	if f.selector, err = labels.Parse(config.GetString("app.namespaceSelector")); err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	f.names = exactNames(f, include)
+	return f, nil
+}
+
+// exactNames returns sorted included names which are not excluded, nil if namespaces
+// can only be selected from the list of all namespaces
+func exactNames(f *namespaceFilter, include []string) []string {
+	if f.all || !f.selector.Empty() {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, pattern := range include {
+		if len(validation.IsDNS1123Label(pattern)) > 0 {
+			return nil
+		}
+		if !f.exclude.Match(pattern) {
+			set[pattern] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandGroups replaces "@group" patterns by the patterns of the group
+func expandGroups(patterns []string, groups map[string][]string) ([]string, error) {
+	var expanded []string
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, groupPrefix) {
+			expanded = append(expanded, pattern)
+			continue
+		}
+		// viper keys are case insensitive
+		group, ok := groups[strings.ToLower(strings.TrimPrefix(pattern, groupPrefix))]
+		if !ok {
+			return nil, fmt.Errorf("unknown namespace group %q", pattern)
+		}
+		expanded = append(expanded, group...)
+	}
+	return expanded, nil
+}
+
+// filter returns sorted names of selected namespaces
+func (f *namespaceFilter) filter(namespaces []*corev1.Namespace) []string {
+	var names []string
+	for _, ns := range namespaces {
+		if !f.all && !f.include.Match(ns.Name) {
+			continue
+		}
+		if f.exclude.Match(ns.Name) || !f.selector.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+		names = append(names, ns.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,121 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	k8client "k8res/internal/k8s/client"
+	"k8res/pkg/logger"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sync"
+	"sync/atomic"
+)
+
+// kubelet summary api (/stats/summary) fields used for ephemeral storage usage,
+// metrics-server does not report it.
+type statsSummary struct {
+	Node struct {
+		Fs *fsStats `json:"fs"` // node rootfs, holding ephemeral storage
+	} `json:"node"`
+	Pods []podStats `json:"pods"`
+}
+
+type podStats struct {
+	PodRef struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"podRef"`
+	Containers       []containerStats `json:"containers"`
+	EphemeralStorage *fsStats         `json:"ephemeral-storage"`
+}
+
+type containerStats struct {
+	Name   string   `json:"name"`
+	Rootfs *fsStats `json:"rootfs"`
+	Logs   *fsStats `json:"logs"`
+}
+
+type fsStats struct {
+	UsedBytes *uint64 `json:"usedBytes"`
+}
+
+func (f *fsStats) used() int64 {
+	if f == nil || f.UsedBytes == nil {
+		return 0
+	}
+	return int64(*f.UsedBytes)
+}
+
+// nodeStatsCache kubelet summaries of nodes, fetched at most once per scan, safe for concurrent use
+type nodeStatsCache struct {
+	k8        *k8client.K8s
+	scan      *scan
+	forbidden *int32 // set for the session of the collector once node proxy is forbidden
+	mu        sync.Mutex
+	nodes     map[string]*nodeStats
+}
+
+// nodeStats summary of a node, fetched once
+type nodeStats struct {
+	once sync.Once
+	pods map[string]*podStats // [ns/pod]
+}
+
+func newNodeStatsCache(s *scan, k8 *k8client.K8s, forbidden *int32) *nodeStatsCache {
+	return &nodeStatsCache{k8: k8, scan: s, forbidden: forbidden, nodes: make(map[string]*nodeStats)}
+}
+
+// get returns the stats of a pod, nil if node summary is not available
+func (c *nodeStatsCache) get(node, namespace, name string) *podStats {
+	if node == "" || atomic.LoadInt32(c.forbidden) != 0 {
+		return nil
+	}
+	c.mu.Lock()
+	stats, ok := c.nodes[node]
+	if !ok {
+		stats = &nodeStats{}
+		c.nodes[node] = stats
+	}
+	c.mu.Unlock()
+	stats.once.Do(func() { stats.pods = c.fetch(node) })
+	return stats.pods[namespace+"/"+name]
+}
+
+// fetch gets node summary through apiserver node proxy, failures only disable ephemeral usage of the node.
+// nodes/proxy is a privileged verb, if it is forbidden ephemeral usage is off for the session with one notice.
+func (c *nodeStatsCache) fetch(node string) map[string]*podStats {
+	ctx, cancel := c.scan.requestCtx()
+	defer cancel()
+	summary, err := getStatsSummary(ctx, c.k8, node)
+	if errors.IsForbidden(err) {
+		if atomic.CompareAndSwapInt32(c.forbidden, 0, 1) {
+			logger.Warnf("get node stats is forbidden, ephemeral usage is not collected: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		c.scan.addError("", node, StepNodeStats, err)
+		return nil
+	}
+	pods := make(map[string]*podStats, len(summary.Pods))
+	for i := range summary.Pods {
+		pod := &summary.Pods[i]
+		pods[pod.PodRef.Namespace+"/"+pod.PodRef.Name] = pod
+	}
+	return pods
+}
+
+// getStatsSummary gets kubelet summary of a node through apiserver node proxy
+func getStatsSummary(ctx context.Context, k8 *k8client.K8s, node string) (*statsSummary, error) {
+	data, err := k8.ClientSet.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(node).SubResource("proxy").Suffix("stats/summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var summary statsSummary
+	if err = json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("decode summary: %w", err)
+	}
+	return &summary, nil
+}
@@ -0,0 +1,211 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	k8client "k8res/internal/k8s/client"
+	"k8res/pkg/config"
+	"k8res/pkg/logger"
+	"k8res/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// boundPodsSelector field selector of pods bound to a node which hold node resources
+const boundPodsSelector = "spec.nodeName!=,status.phase!=Succeeded,status.phase!=Failed"
+
+// NodeRes allocatable resources of a node, with requests and limits of pods on it and node usage
+type NodeRes struct {
+	Name        string
+	Allocatable Resources
+	MaxPods     int64 // allocatable pods
+	Pods        int64 // pods bound to the node, not succeeded or failed
+	Requests    Resources
+	Limits      Resources
+	Usage       Resources // cpu and mem from metrics api, ephemeral from kubelet summary with app.ephemeralUsage
+}
+
+// NodeCollector collects node resources. Nodes and all pods bound to a node, of all namespaces
+// in pending and running phases, are watched by shared informers; pod selectors, app.namespaces
+// and app.phases don't apply, every pod on a node holds its resources.
+type NodeCollector struct {
+	k8         *k8client.K8s
+	extended   *utils.Matcher // extended resources allowlist
+	group      *cacheGroup
+	nodeLister corelisters.NodeLister
+	podLister  corelisters.PodLister
+
+	metricsAvailable bool // metrics.k8s.io is served, checked by Start
+}
+
+// NewNodeCollector creates a node collector of k8s cluster, call Start before GetNodeRes
+func NewNodeCollector(k8 *k8client.K8s) (*NodeCollector, error) {
+	extended, err := extendedResources()
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactory(k8.ClientSet, 0)
+	podFactory := informers.NewSharedInformerFactoryWithOptions(k8.ClientSet, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = boundPodsSelector
+		}))
+	c := &NodeCollector{
+		k8:         k8,
+		extended:   extended,
+		group:      newCacheGroup("nodes and bound pods", factory, podFactory),
+		nodeLister: factory.Core().V1().Nodes().Lister(),
+		podLister:  podFactory.Core().V1().Pods().Lister(),
+	}
+	c.group.add(factory.Core().V1().Nodes().Informer())
+	c.group.add(podFactory.Core().V1().Pods().Informer())
+	return c, nil
+}
+
+// Start checks metrics api, starts informers and waits until caches are synced
+func (c *NodeCollector) Start(ctx context.Context) error {
+	start := time.Now()
+	c.metricsAvailable = metricsAvailable(ctx, c.k8)
+	if err := c.group.start(ctx); err != nil {
+		return err
+	}
+	logger.Infof("informer caches synced in %v", time.Since(start))
+	return nil
+}
+
+// MetricsAvailable reports whether usage is collected from metrics api
+func (c *NodeCollector) MetricsAvailable() bool {
+	return c.metricsAvailable
+}
+
+// Stop stops informers
+func (c *NodeCollector) Stop() {
+	c.group.stop()
+}
+
+// GetNodeRes returns all nodes sorted by name, with requests and limits summed from the pods bound to them.
+// Node usage failures are added to the report, an error is only returned if nodes can not be listed.
+func (c *NodeCollector) GetNodeRes(ctx context.Context) ([]*NodeRes, *ScanReport, error) {
+	report := &ScanReport{Cluster: c.k8.Cluster, Start: time.Now()}
+	list, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, report, err
+	}
+	nodes := make(map[string]*NodeRes, len(list))
+	for _, node := range list {
+		n := &NodeRes{Name: node.Name, MaxPods: node.Status.Allocatable.Pods().Value()}
+		addResourceList(&n.Allocatable, node.Status.Allocatable, c.extended)
+		nodes[node.Name] = n
+	}
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, report, err
+	}
+	s := &scan{ctx: ctx, now: report.Start, extended: c.extended, report: report}
+	for _, pod := range pods {
+		n, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		var podRes PodRes
+		addPodRequests(s, &podRes, pod)
+		n.Pods++
+		n.Requests.AddAll(&podRes.Requests)
+		n.Limits.AddAll(&podRes.Limits)
+	}
+	report.Pods = len(pods)
+	if c.metricsAvailable {
+		c.addNodeUsage(ctx, nodes, report)
+	}
+	if config.GetBool("app.ephemeralUsage") {
+		c.addNodeEphemeralUsage(ctx, nodes, report)
+	}
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]*NodeRes, 0, len(names))
+	for _, name := range names {
+		sorted = append(sorted, nodes[name])
+	}
+	report.Duration = time.Since(report.Start)
+	return sorted, report, nil
+}
+
+// addNodeUsage adds cpu and mem usage of node metrics, nodes have no usage if the list failed
+func (c *NodeCollector) addNodeUsage(ctx context.Context, nodes map[string]*NodeRes, report *ScanReport) {
+	ctx, cancel := withTimeout(ctx, config.GetDuration("app.requestTimeout"))
+	defer cancel()
+	list, err := c.k8.MetricsClient.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if ctx.Err() == nil {
+			report.addError("", "", StepNodeMetrics, err)
+		}
+		return
+	}
+	for _, m := range list.Items {
+		n, ok := nodes[m.Name]
+		if !ok {
+			logger.Debugf("metrics of unknown node %s", m.Name)
+			continue
+		}
+		if m.Usage.Cpu() != nil {
+			n.Usage.CPU.Add(m.Usage.Cpu().MilliValue())
+		}
+		if m.Usage.Memory() != nil {
+			n.Usage.Mem.Add(m.Usage.Memory().Value())
+		}
+	}
+}
+
+// addNodeEphemeralUsage adds rootfs usage of kubelet summaries, fetched by app.workers workers.
+// If node proxy is forbidden the failure is reported once and no node has ephemeral usage.
+func (c *NodeCollector) addNodeEphemeralUsage(ctx context.Context, nodes map[string]*NodeRes, report *ScanReport) {
+	workers := config.GetInt("app.workers")
+	if workers <= 0 {
+		workers = 1
+	}
+	requestTimeout := config.GetDuration("app.requestTimeout")
+	var forbidden int32
+	nodeCh := make(chan *NodeRes)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range nodeCh {
+				if ctx.Err() != nil || atomic.LoadInt32(&forbidden) != 0 {
+					continue
+				}
+				reqCtx, cancel := withTimeout(ctx, requestTimeout)
+				summary, err := getStatsSummary(reqCtx, c.k8, n.Name)
+				cancel()
+				switch {
+				case errors.IsForbidden(err):
+					if atomic.CompareAndSwapInt32(&forbidden, 0, 1) {
+						report.addError("", "", StepNodeStats, fmt.Errorf("ephemeral usage is not collected: %w", err))
+					}
+				case err != nil:
+					if ctx.Err() == nil {
+						report.addError("", n.Name, StepNodeStats, err)
+					}
+				default:
+					n.Usage.Ephemeral.Add(summary.Node.Fs.used())
+				}
+			}
+		}()
+	}
+	for _, n := range nodes {
+		nodeCh <- n
+	}
+	close(nodeCh)
+	wg.Wait()
+}
@@ -3,255 +3,289 @@ package process
 import (
 	"context"
 	"fmt"
-	k8client "k8res/internal/k8s/client"
 	"k8res/pkg/config"
 	"k8res/pkg/logger"
+	"k8res/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	client "k8s.io/client-go/kubernetes/typed/core/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"time"
 )
 
-// AllPodResStore ex: [ns][podName]
-type AllPodResStore map[string]map[string]PodResStore
+// scan state of one GetPodRes call
+type scan struct {
+	ctx            context.Context // done at scan timeout or cancel
+	now            time.Time
+	requestTimeout time.Duration
+	extended       *utils.Matcher // extended resources allowlist
+	nodeStats      *nodeStatsCache
+	report         *ScanReport
+}
 
-// PodResStore ex: [request/limit/usage][cpu/mem/disk][normal/min/mas] = int64
-type PodResStore map[string]map[string]map[string]int64
+// addError adds a failed step to the report, failures caused by an interrupted scan are dropped
+func (s *scan) addError(namespace, name, step string, err error) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.report.addError(namespace, name, step, err)
+}
 
-func GetPodRes(k8 *k8client.K8s, store AllPodResStore) error {
-	var podClient client.PodInterface
-	var pvcClient client.PersistentVolumeClaimInterface
-	var podStore PodResStore
-	var err error
+// requestCtx returns context of a request to api server, with the request timeout
+func (s *scan) requestCtx() (context.Context, context.CancelFunc) {
+	return withTimeout(s.ctx, s.requestTimeout)
+}
 
-	ctx := context.TODO()
-	nsClient := k8.ClientSet.CoreV1().Namespaces()
-	allNamespaces, err := nsClient.List(ctx, metav1.ListOptions{})
+// collectPod samples requests, limits and usage of a pod into podStore, usage only of running pods.
+// Failed steps are added to the scan report and the pod is collected without them.
+// A running pod without a metrics or node stats sample keeps its last usage, min/max only take real samples.
+func (c *Collector) collectPod(s *scan, podStore *PodRes, pod *corev1.Pod, metrics *namespaceMetrics) {
+	podStore.Reset()
+	addPodRequests(s, podStore, pod)
+	updateLifecycle(podStore, pod, c.podWorkload(pod), s.now)
+	c.addPodDisk(s, podStore, pod)
+	if podStore.Scheduling != scheduled {
+		s.report.addUnscheduled(&podStore.Requests)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		podStore.ResetUsage(ResKinds...)
+		return
+	}
+	podMetrics, err := c.podMetrics(s, metrics, pod)
 	if err != nil {
-		return err
+		s.addError(pod.Namespace, pod.Name, StepPodMetrics, err)
 	}
-	usedNamespaces := getNamespaces(allNamespaces.Items)
-
-	for _, ns := range usedNamespaces {
-		podClient = k8.ClientSet.CoreV1().Pods(ns)
-		pvcClient = k8.ClientSet.CoreV1().PersistentVolumeClaims(ns)
-		pods, err := podClient.List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return err
+	var stats *podStats
+	if s.nodeStats != nil {
+		stats = s.nodeStats.get(pod.Spec.NodeName, pod.Namespace, pod.Name)
+	}
+	// usage of an interrupted sample is incomplete
+	complete := s.ctx.Err() == nil
+	if podMetrics != nil {
+		podStore.ResetUsage(metricsKinds...)
+		addPodUsage(podStore, podMetrics)
+		if complete {
+			podStore.UpdateUsageMinMax(metricsKinds...)
 		}
-
-		for _, pod := range pods.Items {
-			if pod.Status.Phase != "Running" {
-				logger.Debugf("pod %s is not running", pod.Name)
-				continue
-			}
-			if _, ok := store[pod.Namespace]; !ok {
-				store[pod.Namespace] = make(map[string]PodResStore)
-			}
-			if _, ok := store[pod.Namespace][pod.Name]; !ok {
-				store[pod.Namespace][pod.Name] = make(PodResStore)
-			}
-			podStore = store[pod.Namespace][pod.Name]
-
-			podStoreInit(podStore)
-			resetNormalCount(podStore)
-
-			// requests & limits
-			for _, container := range pod.Spec.Containers {
-				if container.Resources.Requests.Cpu() != nil {
-					podStore["request"]["cpu"]["normal"] += container.Resources.Requests.Cpu().MilliValue()
-				}
-				if container.Resources.Requests.Memory() != nil {
-					podStore["request"]["mem"]["normal"] += container.Resources.Requests.Memory().Value()
-				}
-				if container.Resources.Limits.Cpu() != nil {
-					podStore["limit"]["cpu"]["normal"] += container.Resources.Limits.Cpu().MilliValue()
-				}
-				if container.Resources.Limits.Memory() != nil {
-					podStore["limit"]["mem"]["normal"] += container.Resources.Limits.Memory().Value()
-				}
-			}
-
-			// disk
-			for _, volume := range pod.Spec.Volumes {
-				if volume.VolumeSource.PersistentVolumeClaim != nil {
-					pvc, err := pvcClient.Get(ctx, volume.VolumeSource.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
-					if err != nil {
-						return err
-					}
-					if pvc.Spec.Resources.Requests.Storage() != nil {
-						podStore["request"]["disk"]["normal"] += pvc.Spec.Resources.Requests.Storage().Value()
-					}
-					if pvc.Spec.Resources.Limits.Storage() != nil {
-						podStore["limit"]["disk"]["normal"] += pvc.Spec.Resources.Limits.Storage().Value()
-					}
-				}
-			}
-
-			// usage
-			mc := k8.MetricsClient.MetricsV1beta1().PodMetricses(pod.Namespace)
-			podMetrics, err := mc.Get(ctx, pod.Name, metav1.GetOptions{})
-			if err == nil {
-				for _, container := range podMetrics.Containers {
-					if container.Usage.Cpu() != nil {
-						podStore["usage"]["cpu"]["normal"] += container.Usage.Cpu().MilliValue()
-					}
-					if container.Usage.Memory() != nil {
-						podStore["usage"]["mem"]["normal"] += container.Usage.Memory().Value()
-					}
-					if container.Usage.Storage() != nil {
-						podStore["usage"]["disk"]["normal"] += container.Usage.Storage().Value()
-					}
-				}
-			} else {
-				if err.(*errors.StatusError).ErrStatus.Code != 404 {
-					return err
-				}
-			}
-			updateMinMaxUsage(podStore)
+	}
+	if stats != nil {
+		podStore.ResetUsage(ResEphemeral)
+		addEphemeralUsage(podStore, stats)
+		if complete {
+			podStore.UpdateUsageMinMax(ResEphemeral)
 		}
 	}
-	return nil
 }
 
-func resetNormalCount(podStore PodResStore) {
-	podStore["request"]["cpu"]["normal"] = 0
-	podStore["request"]["mem"]["normal"] = 0
-	podStore["request"]["disk"]["normal"] = 0
-	podStore["limit"]["cpu"]["normal"] = 0
-	podStore["limit"]["mem"]["normal"] = 0
-	podStore["limit"]["disk"]["normal"] = 0
-	podStore["usage"]["cpu"]["normal"] = 0
-	podStore["usage"]["mem"]["normal"] = 0
-	podStore["usage"]["disk"]["normal"] = 0
-}
+// metricsKinds usage kinds sampled from metrics api
+var metricsKinds = []ResKind{ResCPU, ResMem, ResDisk}
 
-func podStoreInit(podStore PodResStore) {
-	if _, ok := podStore["request"]; !ok {
-		podStore["request"] = make(map[string]map[string]int64)
+// addPodRequests adds requests & limits of containers, and the effective values of the pod
+func addPodRequests(s *scan, podStore *PodRes, pod *corev1.Pod) {
+	var initRequests, initLimits Resources
+	for _, container := range pod.Spec.InitContainers {
+		cStore := podStore.GetOrCreateContainer(container.Name)
+		cStore.Init = true
+		addResourceList(&cStore.Requests, container.Resources.Requests, s.extended)
+		addResourceList(&cStore.Limits, container.Resources.Limits, s.extended)
+		initRequests.MaxAll(&cStore.Requests)
+		initLimits.MaxAll(&cStore.Limits)
 	}
-	if _, ok := podStore["request"]["cpu"]; !ok {
-		podStore["request"]["cpu"] = make(map[string]int64)
-	}
-	if _, ok := podStore["request"]["mem"]; !ok {
-		podStore["request"]["mem"] = make(map[string]int64)
-	}
-	if _, ok := podStore["request"]["disk"]; !ok {
-		podStore["request"]["disk"] = make(map[string]int64)
+	for _, container := range pod.Spec.Containers {
+		cStore := podStore.GetOrCreateContainer(container.Name)
+		addResourceList(&cStore.Requests, container.Resources.Requests, s.extended)
+		addResourceList(&cStore.Limits, container.Resources.Limits, s.extended)
+		podStore.AppRequests.AddAll(&cStore.Requests)
+		podStore.AppLimits.AddAll(&cStore.Limits)
 	}
+	podStore.Requests.AddAll(&podStore.AppRequests)
+	podStore.Requests.MaxAll(&initRequests)
+	addResourceList(&podStore.Requests, pod.Spec.Overhead, s.extended)
+	podStore.Limits.AddAll(&podStore.AppLimits)
+	podStore.Limits.MaxAll(&initLimits)
+	addResourceList(&podStore.Limits, pod.Spec.Overhead, s.extended)
+}
 
-	if _, ok := podStore["limit"]; !ok {
-		podStore["limit"] = make(map[string]map[string]int64)
-	}
-	if _, ok := podStore["limit"]["cpu"]; !ok {
-		podStore["limit"]["cpu"] = make(map[string]int64)
-	}
-	if _, ok := podStore["limit"]["mem"]; !ok {
-		podStore["limit"]["mem"] = make(map[string]int64)
-	}
-	if _, ok := podStore["limit"]["disk"]; !ok {
-		podStore["limit"]["disk"] = make(map[string]int64)
+// addPodDisk adds pvc requests & limits of pod volumes, with capacity and storage class of the bound pv
+func (c *Collector) addPodDisk(s *scan, podStore *PodRes, pod *corev1.Pod) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.VolumeSource.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := c.cache(pod.Namespace).pvcLister.PersistentVolumeClaims(pod.Namespace).Get(volume.VolumeSource.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			s.addError(pod.Namespace, pod.Name, StepPVC, err)
+			continue
+		}
+		if pvc.Spec.Resources.Requests.Storage() != nil {
+			podStore.Requests.Disk.Add(pvc.Spec.Resources.Requests.Storage().Value())
+			podStore.AppRequests.Disk.Add(pvc.Spec.Resources.Requests.Storage().Value())
+		}
+		if pvc.Spec.Resources.Limits.Storage() != nil {
+			podStore.Limits.Disk.Add(pvc.Spec.Resources.Limits.Storage().Value())
+			podStore.AppLimits.Disk.Add(pvc.Spec.Resources.Limits.Storage().Value())
+		}
+		podStore.Volumes = append(podStore.Volumes, c.volumeRes(pvc))
 	}
+}
 
-	if _, ok := podStore["usage"]; !ok {
-		podStore["usage"] = make(map[string]map[string]int64)
+// volumeRes pvc request with capacity and storage class of the bound pv,
+// pvc status capacity is used if pv is not watched or not found
+func (c *Collector) volumeRes(pvc *corev1.PersistentVolumeClaim) VolumeRes {
+	v := VolumeRes{
+		Claim:     pvc.Name,
+		Requested: pvc.Spec.Resources.Requests.Storage().Value(),
+		Capacity:  pvc.Status.Capacity.Storage().Value(),
+	}
+	if pvc.Spec.StorageClassName != nil {
+		v.StorageClass = *pvc.Spec.StorageClassName
 	}
-	if _, ok := podStore["usage"]["cpu"]; !ok {
-		podStore["usage"]["cpu"] = make(map[string]int64)
+	if c.pvLister == nil || pvc.Spec.VolumeName == "" {
+		return v
 	}
-	if _, ok := podStore["usage"]["mem"]; !ok {
-		podStore["usage"]["mem"] = make(map[string]int64)
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		logger.Debugf("get pv %s of pvc %s/%s failed: %v", pvc.Spec.VolumeName, pvc.Namespace, pvc.Name, err)
+		return v
 	}
-	if _, ok := podStore["usage"]["disk"]; !ok {
-		podStore["usage"]["disk"] = make(map[string]int64)
+	v.Capacity = pv.Spec.Capacity.Storage().Value()
+	if pv.Spec.StorageClassName != "" {
+		v.StorageClass = pv.Spec.StorageClassName
 	}
+	return v
 }
 
-func updateMinMaxUsage(podStore PodResStore) {
-	if podStore["usage"]["cpu"]["min"] == 0 {
-		podStore["usage"]["cpu"]["min"] = podStore["usage"]["cpu"]["normal"]
+// addPodUsage adds container usage from pod metrics, and records a usage sample of the pod
+func addPodUsage(podStore *PodRes, podMetrics *metricsv1beta1.PodMetrics) {
+	if podMetrics == nil {
+		return
 	}
-	if podStore["usage"]["cpu"]["max"] == 0 {
-		podStore["usage"]["cpu"]["max"] = podStore["usage"]["cpu"]["normal"]
-	}
-	if podStore["usage"]["mem"]["min"] == 0 {
-		podStore["usage"]["mem"]["min"] = podStore["usage"]["mem"]["normal"]
-	}
-	if podStore["usage"]["mem"]["max"] == 0 {
-		podStore["usage"]["mem"]["max"] = podStore["usage"]["mem"]["normal"]
+	for _, container := range podMetrics.Containers {
+		cStore := podStore.GetOrCreateContainer(container.Name)
+		if container.Usage.Cpu() != nil {
+			cStore.Usage.CPU.Add(container.Usage.Cpu().MilliValue())
+		}
+		if container.Usage.Memory() != nil {
+			cStore.Usage.Mem.Add(container.Usage.Memory().Value())
+		}
+		if container.Usage.Storage() != nil {
+			cStore.Usage.Disk.Add(container.Usage.Storage().Value())
+		}
+		podStore.Usage.CPU.Add(cStore.Usage.CPU.Current)
+		podStore.Usage.Mem.Add(cStore.Usage.Mem.Current)
+		podStore.Usage.Disk.Add(cStore.Usage.Disk.Current)
 	}
-	if podStore["usage"]["disk"]["min"] == 0 {
-		podStore["usage"]["disk"]["min"] = podStore["usage"]["disk"]["normal"]
+	podStore.UsageStats.CPU.Add(podStore.Usage.CPU.Current)
+	podStore.UsageStats.Mem.Add(podStore.Usage.Mem.Current)
+}
+
+// extendedResources compiles the allowlist of extended resources app.extendedResources, empty is all
+func extendedResources() (*utils.Matcher, error) {
+	patterns := config.GetStringSlice("app.extendedResources")
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
 	}
-	if podStore["usage"]["disk"]["max"] == 0 {
-		podStore["usage"]["disk"]["max"] = podStore["usage"]["disk"]["normal"]
+	m, err := utils.NewMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended resources: %w", err)
 	}
+	return m, nil
+}
 
-	// update min max
-	if podStore["usage"]["cpu"]["normal"] < podStore["usage"]["cpu"]["min"] {
-		podStore["usage"]["cpu"]["min"] = podStore["usage"]["cpu"]["normal"]
+// updateLifecycle updates seen times, node, qos, labels, phase, scheduling and container restarts of a pod
+func updateLifecycle(podStore *PodRes, pod *corev1.Pod, workload string, now time.Time) {
+	if podStore.UID != "" && podStore.UID != string(pod.UID) {
+		podStore.Recreations++
 	}
-	if podStore["usage"]["cpu"]["normal"] > podStore["usage"]["cpu"]["max"] {
-		podStore["usage"]["cpu"]["max"] = podStore["usage"]["cpu"]["normal"]
+	podStore.UID = string(pod.UID)
+	if podStore.FirstSeen.IsZero() {
+		podStore.FirstSeen = now
 	}
+	podStore.LastSeen = now
+	podStore.Samples++
+	podStore.Gone = false
+	podStore.EndTime = time.Time{}
+	podStore.Workload = workload
+	podStore.NodeName = pod.Spec.NodeName
+	podStore.QOSClass = string(pod.Status.QOSClass)
+	podStore.Labels = pod.Labels
+	podStore.Phase = string(pod.Status.Phase)
+	podStore.Scheduling, podStore.SchedulingMessage = podScheduling(pod)
 
-	if podStore["usage"]["mem"]["normal"] < podStore["usage"]["mem"]["min"] {
-		podStore["usage"]["mem"]["min"] = podStore["usage"]["mem"]["normal"]
-	}
-	if podStore["usage"]["mem"]["normal"] > podStore["usage"]["mem"]["max"] {
-		podStore["usage"]["mem"]["max"] = podStore["usage"]["mem"]["normal"]
+	var statuses []corev1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		cStore := podStore.Container(status.Name)
+		if cStore == nil {
+			continue
+		}
+		cStore.Restarts = status.RestartCount
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			cStore.LastTermination = Termination{
+				Reason:   terminated.Reason,
+				ExitCode: terminated.ExitCode,
+				Time:     terminated.FinishedAt.Time,
+			}
+		}
 	}
+}
 
-	if podStore["usage"]["disk"]["normal"] < podStore["usage"]["disk"]["min"] {
-		podStore["usage"]["disk"]["min"] = podStore["usage"]["disk"]["normal"]
+// scheduled scheduling status of a pod bound to a node
+const scheduled = "Scheduled"
+
+// podScheduling returns reason and message of the PodScheduled condition of a pod,
+// "Scheduled" if it is bound to a node, "Pending" if the scheduler has not looked at it yet
+func podScheduling(pod *corev1.Pod) (string, string) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != corev1.PodScheduled {
+			continue
+		}
+		if condition.Status == corev1.ConditionTrue {
+			return scheduled, ""
+		}
+		if condition.Reason == "" {
+			return "NotScheduled", condition.Message
+		}
+		return condition.Reason, condition.Message
 	}
-	if podStore["usage"]["disk"]["normal"] > podStore["usage"]["disk"]["max"] {
-		podStore["usage"]["disk"]["max"] = podStore["usage"]["disk"]["normal"]
+	if pod.Spec.NodeName != "" {
+		return scheduled, ""
 	}
+	return string(corev1.PodPending), ""
 }
 
-func getNamespaces(allNamespaces []corev1.Namespace) []string {
-	var usedNamespaceName []string
-	var allNamespacesName []string
-	usedNamespaceNamesSet := make(map[string]bool)
-
-	for _, namespace := range allNamespaces {
-		allNamespacesName = append(allNamespacesName, namespace.Name)
+// addResourceList adds resources of a container or overhead resource list to r,
+// extended resources are added if its name matches the extended allowlist
+func addResourceList(r *Resources, list corev1.ResourceList, extended *utils.Matcher) {
+	if list.Cpu() != nil {
+		r.CPU.Add(list.Cpu().MilliValue())
 	}
-	for _, namespace := range config.GetStringSlice("app.namespaces") {
-		usedNamespaceNamesSet[namespace] = true
+	if list.Memory() != nil {
+		r.Mem.Add(list.Memory().Value())
 	}
-	if _, ok := usedNamespaceNamesSet["all"]; ok {
-		return allNamespacesName
+	if list.StorageEphemeral() != nil {
+		r.Ephemeral.Add(list.StorageEphemeral().Value())
 	}
-	for _, ns := range allNamespacesName {
-		if _, ok := usedNamespaceNamesSet[ns]; ok {
-			usedNamespaceName = append(usedNamespaceName, ns)
+	for name, quantity := range list {
+		switch name {
+		case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage,
+			corev1.ResourceStorage, corev1.ResourcePods:
+			continue
+		}
+		if extended.Match(string(name)) {
+			r.GetOrCreate(ResKind(name)).Add(quantity.Value())
 		}
 	}
-	return usedNamespaceName
 }
 
-func ExportPodRes(store AllPodResStore) {
-	fmt.Println()
-	for ns, pods := range store {
-		for name, pod := range pods {
-			fmt.Print(ns, ", ")
-			fmt.Print(name, ", ")
-			fmt.Print(pod["request"]["cpu"]["normal"], ", ")
-			fmt.Print(pod["request"]["mem"]["normal"], ", ")
-			fmt.Print(pod["request"]["disk"]["normal"], ", ")
-			fmt.Print(pod["limit"]["cpu"]["normal"], ", ")
-			fmt.Print(pod["limit"]["mem"]["normal"], ", ")
-			fmt.Print(pod["limit"]["disk"]["normal"], ", ")
-			fmt.Print(pod["usage"]["cpu"]["min"], ", ")
-			fmt.Print(pod["usage"]["cpu"]["normal"], ", ")
-			fmt.Print(pod["usage"]["cpu"]["max"], ", ")
-			fmt.Print(pod["usage"]["mem"]["min"], ", ")
-			fmt.Print(pod["usage"]["mem"]["normal"], ", ")
-			fmt.Print(pod["usage"]["mem"]["max"], ", ")
-			fmt.Print(pod["usage"]["disk"]["normal"], ", ")
-			fmt.Println()
+// addEphemeralUsage adds ephemeral storage usage from kubelet stats, container usage is rootfs + logs
+func addEphemeralUsage(podStore *PodRes, stats *podStats) {
+	if stats == nil {
+		return
+	}
+	podStore.Usage.Ephemeral.Add(stats.EphemeralStorage.used())
+	for _, container := range stats.Containers {
+		if cStore := podStore.Container(container.Name); cStore != nil {
+			cStore.Usage.Ephemeral.Add(container.Rootfs.used() + container.Logs.used())
 		}
 	}
 }
@@ -0,0 +1,161 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scan steps of ScanError
+const (
+	StepPods        = "pods"
+	StepMetrics     = "metrics"
+	StepPodMetrics  = "pod-metrics"
+	StepPVC         = "pvc"
+	StepNodeStats   = "node-stats"
+	StepNodeMetrics = "node-metrics"
+	StepScan        = "scan"
+	StepCluster     = "cluster"
+)
+
+// ScanError failure of one step of a scan, the scan goes on without the failed part
+type ScanError struct {
+	Cluster   string // empty for the default cluster
+	Namespace string
+	Name      string // pod or node name, empty for namespace steps
+	Step      string
+	Err       error
+}
+
+func (e ScanError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Step, e.object(), e.Err)
+}
+
+func (e ScanError) object() string {
+	if e.Cluster != "" {
+		return e.Cluster + ":" + e.localObject()
+	}
+	return e.localObject()
+}
+
+func (e ScanError) localObject() string {
+	switch {
+	case e.Namespace == "" && e.Name == "":
+		return "all"
+	case e.Namespace == "":
+		return e.Name
+	case e.Name == "":
+		return e.Namespace
+	}
+	return e.Namespace + "/" + e.Name
+}
+
+// ScanReport summary of a GetPodRes call, safe for concurrent use while scanning
+type ScanReport struct {
+	Cluster    string // empty for the default cluster
+	Start      time.Time
+	Duration   time.Duration
+	Namespaces int
+	Pods       int // pods collected
+	Errors     []ScanError
+
+	// pods not bound to a node yet, with their requests: demand the cluster does not meet
+	Unscheduled         int
+	UnscheduledRequests Resources
+
+	mu sync.Mutex
+}
+
+func (r *ScanReport) addPods(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pods += n
+}
+
+func (r *ScanReport) addUnscheduled(requests *Resources) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Unscheduled++
+	r.UnscheduledRequests.AddAll(requests)
+}
+
+func (r *ScanReport) addError(namespace, name, step string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, ScanError{Cluster: r.Cluster, Namespace: namespace, Name: name, Step: step, Err: err})
+}
+
+// Warnings scan errors of one or more scans, the same failure of several scans is kept once with a count.
+// Safe for concurrent use by scans of several clusters.
+type Warnings struct {
+	errors map[string]*warning
+	mu     sync.Mutex
+}
+
+type warning struct {
+	ScanError
+	count int
+}
+
+// NewWarnings creates empty warnings
+func NewWarnings() *Warnings {
+	return &Warnings{errors: make(map[string]*warning)}
+}
+
+// Add adds errors of a scan report, the last error message of a failure is kept
+func (w *Warnings) Add(report *ScanReport) {
+	if report == nil {
+		return
+	}
+	for _, e := range report.Errors {
+		w.add(e)
+	}
+}
+
+// AddClusterError adds a failure of a whole cluster, e.g. it can not be connected
+func (w *Warnings) AddClusterError(cluster string, err error) {
+	w.add(ScanError{Cluster: cluster, Step: StepCluster, Err: err})
+}
+
+func (w *Warnings) add(e ScanError) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := e.Step + " " + e.object()
+	if _, ok := w.errors[key]; !ok {
+		w.errors[key] = &warning{}
+	}
+	w.errors[key].ScanError = e
+	w.errors[key].count++
+}
+
+// Len returns the number of different failures
+func (w *Warnings) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.errors)
+}
+
+// Print prints warnings sorted by step and object
+func (w *Warnings) Print(out io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.errors) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(w.errors))
+	for key := range w.errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(out, "\nWARNINGS (%d):\n", len(keys))
+	for _, key := range keys {
+		e := w.errors[key]
+		if e.count > 1 {
+			fmt.Fprintf(out, "%s (%d times)\n", e.Error(), e.count)
+		} else {
+			fmt.Fprintln(out, e.Error())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// usage statistics state file content, [ns/pod]
+type usageStatsState map[string]*PodUsageStats
+
+// LoadUsageStats loads usage statistics saved by SaveUsageStats into store, so sketches of
+// multiple monitor runs add up. They are merged into a pod when a scan first sees it,
+// pods that are not seen get no record. A missing file is not an error.
+func LoadUsageStats(store *AllPodResStore, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := make(usageStatsState)
+	if err = json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("decode usage stats %s: %w", path, err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for key, stats := range state {
+		if !strings.Contains(key, "/") || stats == nil {
+			continue
+		}
+		if loaded, ok := store.loadedStats[key]; ok {
+			if err = loaded.Merge(stats); err != nil {
+				return fmt.Errorf("merge usage stats of %s: %w", key, err)
+			}
+			continue
+		}
+		store.loadedStats[key] = stats
+	}
+	return nil
+}
+
+// SaveUsageStats writes usage statistics of all pods in store to path,
+// with loaded statistics of pods not seen in this session
+func SaveUsageStats(store *AllPodResStore, path string) error {
+	state := make(usageStatsState, store.Len())
+	store.mu.RLock()
+	for key, stats := range store.loadedStats {
+		state[key] = stats
+	}
+	store.mu.RUnlock()
+	for _, pod := range store.All() {
+		state[pod.Namespace+"/"+pod.Name] = &pod.UsageStats
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,496 @@
+package process
+
+import (
+	"k8res/pkg/sketch"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResKind resource kind tracked by the store
+type ResKind string
+
+const (
+	ResCPU  ResKind = "cpu"  // milli cores
+	ResMem  ResKind = "mem"  // bytes
+	ResDisk ResKind = "disk" // bytes, pvc requests
+	// ResEphemeral ephemeral-storage bytes, container writable layer, logs and emptyDir
+	ResEphemeral ResKind = "ephemeral"
+)
+
+// ResKinds all resource kinds in the store, in column order of exports.
+// A new kind is added here, as a Resources field and in Resources.Get, exports loop over ResKinds.
+var ResKinds = []ResKind{ResCPU, ResMem, ResDisk, ResEphemeral}
+
+// ResValue current value of a resource with the min/max seen across samples
+type ResValue struct {
+	Current int64
+	Min     int64
+	Max     int64
+}
+
+// Reset clears the current value before a new sample, min/max are kept
+func (v *ResValue) Reset() {
+	v.Current = 0
+}
+
+// Add adds n to the current value
+func (v *ResValue) Add(n int64) {
+	v.Current += n
+}
+
+// UpdateMinMax updates min/max with the current value
+func (v *ResValue) UpdateMinMax() {
+	if v.Min == 0 || v.Current < v.Min {
+		v.Min = v.Current
+	}
+	if v.Max == 0 || v.Current > v.Max {
+		v.Max = v.Current
+	}
+}
+
+// Resources one ResValue for each ResKind, plus extended resources
+// (gpu, hugepages, device plugins) keyed by the kubernetes resource name
+type Resources struct {
+	CPU       ResValue
+	Mem       ResValue
+	Disk      ResValue
+	Ephemeral ResValue
+	Extended  map[string]*ResValue
+}
+
+// Get returns the value of kind, extended resources use resource name as kind, nil if not exist
+func (r *Resources) Get(kind ResKind) *ResValue {
+	switch kind {
+	case ResCPU:
+		return &r.CPU
+	case ResMem:
+		return &r.Mem
+	case ResDisk:
+		return &r.Disk
+	case ResEphemeral:
+		return &r.Ephemeral
+	}
+	return r.Extended[string(kind)]
+}
+
+// GetOrCreate returns the value of kind, creates an extended resource if not exist
+func (r *Resources) GetOrCreate(kind ResKind) *ResValue {
+	if v := r.Get(kind); v != nil {
+		return v
+	}
+	if r.Extended == nil {
+		r.Extended = make(map[string]*ResValue)
+	}
+	v := &ResValue{}
+	r.Extended[string(kind)] = v
+	return v
+}
+
+// ExtendedNames returns sorted extended resource names
+func (r *Resources) ExtendedNames() []string {
+	names := make([]string, 0, len(r.Extended))
+	for name := range r.Extended {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// kinds returns ResKinds followed by extended resource names
+func (r *Resources) kinds() []ResKind {
+	kinds := append([]ResKind{}, ResKinds...)
+	for _, name := range r.ExtendedNames() {
+		kinds = append(kinds, ResKind(name))
+	}
+	return kinds
+}
+
+// Reset resets current value of all kinds
+func (r *Resources) Reset() {
+	for _, kind := range r.kinds() {
+		r.Get(kind).Reset()
+	}
+}
+
+// AddAll adds current values of o to r
+func (r *Resources) AddAll(o *Resources) {
+	for _, kind := range o.kinds() {
+		r.GetOrCreate(kind).Add(o.Get(kind).Current)
+	}
+}
+
+// SumAll adds current, min and max values of o to r
+func (r *Resources) SumAll(o *Resources) {
+	for _, kind := range o.kinds() {
+		v, ov := r.GetOrCreate(kind), o.Get(kind)
+		v.Current += ov.Current
+		v.Min += ov.Min
+		v.Max += ov.Max
+	}
+}
+
+// MergeMinMax takes the lower min and the higher max of r and o, a min of 0 is not set
+func (r *Resources) MergeMinMax(o *Resources) {
+	for _, kind := range o.kinds() {
+		v, ov := r.GetOrCreate(kind), o.Get(kind)
+		if v.Min == 0 || (ov.Min != 0 && ov.Min < v.Min) {
+			v.Min = ov.Min
+		}
+		if ov.Max > v.Max {
+			v.Max = ov.Max
+		}
+	}
+}
+
+// MaxAll sets current value of each kind to the larger one of r and o
+func (r *Resources) MaxAll(o *Resources) {
+	for _, kind := range o.kinds() {
+		if v := o.Get(kind).Current; v > r.GetOrCreate(kind).Current {
+			r.Get(kind).Current = v
+		}
+	}
+}
+
+// UpdateMinMax updates min/max of all kinds
+func (r *Resources) UpdateMinMax() {
+	for _, kind := range r.kinds() {
+		r.Get(kind).UpdateMinMax()
+	}
+}
+
+// PodUsageStats usage statistics of a pod, bounded memory for long monitor sessions
+type PodUsageStats struct {
+	CPU sketch.Sketch `json:"cpu"`
+	Mem sketch.Sketch `json:"mem"`
+}
+
+// Merge merges usage statistics of another session of the pod
+func (s *PodUsageStats) Merge(o *PodUsageStats) error {
+	if err := s.CPU.Merge(&o.CPU); err != nil {
+		return err
+	}
+	return s.Mem.Merge(&o.Mem)
+}
+
+// Termination last termination of a container
+type Termination struct {
+	Reason   string
+	ExitCode int32
+	Time     time.Time
+}
+
+// VolumeRes pvc volume of a pod
+type VolumeRes struct {
+	Claim        string
+	StorageClass string
+	Requested    int64 // pvc request bytes
+	Capacity     int64 // bound pv capacity bytes, 0 if not bound
+}
+
+// ContainerRes resource record of a container
+type ContainerRes struct {
+	Name            string
+	Init            bool // init container
+	Requests        Resources
+	Limits          Resources
+	Usage           Resources
+	Restarts        int32
+	LastTermination Termination
+}
+
+// Reset resets current requests and limits before a new sample of the container
+func (c *ContainerRes) Reset() {
+	c.Requests.Reset()
+	c.Limits.Reset()
+}
+
+// PodRes resource record of a pod
+// Requests and Limits are effective values the way kube-scheduler computes them:
+// max(sum of app containers, largest init container) + pod overhead.
+// AppRequests and AppLimits are the sum of app containers only.
+type PodRes struct {
+	Namespace   string
+	Name        string
+	Requests    Resources
+	Limits      Resources
+	AppRequests Resources
+	AppLimits   Resources
+	Usage       Resources
+	UsageStats  PodUsageStats   // one sample each scan with metrics
+	Containers  []*ContainerRes // init containers first, in pod spec order
+	Volumes     []VolumeRes     // pvc volumes of the last sample
+
+	// lifecycle
+	UID               string
+	Recreations       int       // times a new pod with the same name was seen
+	FirstSeen         time.Time // first scan time
+	LastSeen          time.Time // last scan time
+	Samples           int64     // number of scans which seen the pod
+	NodeName          string
+	QOSClass          string
+	Labels            map[string]string // pod labels of the last sample
+	Phase             string
+	Scheduling        string // PodScheduled condition reason, e.g. Unschedulable, "Scheduled" once bound to a node
+	SchedulingMessage string
+	Workload          string    // top controller kind/name of the owner chain, empty for bare pods
+	Gone              bool      // deleted or not running any more
+	EndTime           time.Time // first scan time the pod was gone
+}
+
+// Restarts returns restart count of all containers
+func (p *PodRes) Restarts() int32 {
+	var restarts int32
+	for _, c := range p.Containers {
+		restarts += c.Restarts
+	}
+	return restarts
+}
+
+// LastTermination returns the latest termination of all containers
+func (p *PodRes) LastTermination() Termination {
+	var last Termination
+	for _, c := range p.Containers {
+		if c.LastTermination.Time.After(last.Time) {
+			last = c.LastTermination
+		}
+	}
+	return last
+}
+
+// Reset resets current requests, limits and volumes of the pod and its containers before a new sample,
+// usage is kept until a new usage sample, see ResetUsage
+func (p *PodRes) Reset() {
+	p.Requests.Reset()
+	p.Limits.Reset()
+	p.AppRequests.Reset()
+	p.AppLimits.Reset()
+	for _, c := range p.Containers {
+		c.Reset()
+	}
+	p.Volumes = p.Volumes[:0]
+}
+
+// ResetUsage resets current usage of kinds of the pod and its containers before a new usage sample
+func (p *PodRes) ResetUsage(kinds ...ResKind) {
+	for _, kind := range kinds {
+		p.Usage.GetOrCreate(kind).Reset()
+		for _, c := range p.Containers {
+			c.Usage.GetOrCreate(kind).Reset()
+		}
+	}
+}
+
+// UpdateUsageMinMax updates usage min/max of kinds of the pod and its containers with a new usage sample
+func (p *PodRes) UpdateUsageMinMax(kinds ...ResKind) {
+	for _, kind := range kinds {
+		p.Usage.GetOrCreate(kind).UpdateMinMax()
+		for _, c := range p.Containers {
+			c.Usage.GetOrCreate(kind).UpdateMinMax()
+		}
+	}
+}
+
+// DiskCapacity returns the sum of pv capacity of pvc volumes
+func (p *PodRes) DiskCapacity() int64 {
+	var capacity int64
+	for _, v := range p.Volumes {
+		capacity += v.Capacity
+	}
+	return capacity
+}
+
+// StorageClasses returns sorted storage classes of pvc volumes
+func (p *PodRes) StorageClasses() []string {
+	set := make(map[string]bool)
+	for _, v := range p.Volumes {
+		if v.StorageClass != "" {
+			set[v.StorageClass] = true
+		}
+	}
+	classes := make([]string, 0, len(set))
+	for class := range set {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// Container returns the record of a container, nil if not exist
+func (p *PodRes) Container(name string) *ContainerRes {
+	for _, c := range p.Containers {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetOrCreateContainer returns the record of a container, creates it if not exist
+func (p *PodRes) GetOrCreateContainer(name string) *ContainerRes {
+	if c := p.Container(name); c != nil {
+		return c
+	}
+	c := &ContainerRes{Name: name}
+	p.Containers = append(p.Containers, c)
+	return c
+}
+
+// AllPodResStore resource records of all pods, indexed by namespace and pod name.
+// It is safe for concurrent use, a pod record must be written by only one goroutine at a time.
+type AllPodResStore struct {
+	mu   sync.RWMutex
+	pods map[string]map[string]*PodRes
+	// usage statistics loaded by LoadUsageStats of pods not seen yet, [ns/pod]
+	loadedStats map[string]*PodUsageStats
+}
+
+// NewAllPodResStore creates an empty store
+func NewAllPodResStore() *AllPodResStore {
+	return &AllPodResStore{
+		pods:        make(map[string]map[string]*PodRes),
+		loadedStats: make(map[string]*PodUsageStats),
+	}
+}
+
+// takeLoadedStats removes and returns loaded usage statistics of a pod, nil if there are none
+func (s *AllPodResStore) takeLoadedStats(namespace, name string) *PodUsageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := namespace + "/" + name
+	stats := s.loadedStats[key]
+	delete(s.loadedStats, key)
+	return stats
+}
+
+// Get returns the record of a pod, nil if not exist
+func (s *AllPodResStore) Get(namespace, name string) *PodRes {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pods[namespace][name]
+}
+
+// GetOrCreate returns the record of a pod, creates it if not exist
+func (s *AllPodResStore) GetOrCreate(namespace, name string) *PodRes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pods[namespace]; !ok {
+		s.pods[namespace] = make(map[string]*PodRes)
+	}
+	pod, ok := s.pods[namespace][name]
+	if !ok {
+		pod = &PodRes{Namespace: namespace, Name: name}
+		s.pods[namespace][name] = pod
+	}
+	return pod
+}
+
+// Namespaces returns sorted namespaces which have pod records
+func (s *AllPodResStore) Namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	namespaces := make([]string, 0, len(s.pods))
+	for ns := range s.pods {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Pods returns records of a namespace sorted by pod name
+func (s *AllPodResStore) Pods(namespace string) []*PodRes {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pods := make([]*PodRes, 0, len(s.pods[namespace]))
+	for _, pod := range s.pods[namespace] {
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods
+}
+
+// All returns all records sorted by namespace and pod name
+func (s *AllPodResStore) All() []*PodRes {
+	var pods []*PodRes
+	for _, ns := range s.Namespaces() {
+		pods = append(pods, s.Pods(ns)...)
+	}
+	return pods
+}
+
+// MarkGone marks pods not seen by the scan at scanTime as gone, in all namespaces but failed ones,
+// so pods of deleted or no longer selected namespaces are gone too
+func (s *AllPodResStore) MarkGone(failed map[string]bool, scanTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ns, pods := range s.pods {
+		if failed[ns] {
+			continue
+		}
+		for _, pod := range pods {
+			if pod.Gone || !pod.LastSeen.Before(scanTime) {
+				continue
+			}
+			pod.Gone = true
+			pod.EndTime = scanTime
+		}
+	}
+}
+
+// Len returns the number of pod records
+func (s *AllPodResStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, pods := range s.pods {
+		n += len(pods)
+	}
+	return n
+}
+
+// WorkloadRes resources of the live pods of a workload, usage min/max are sums of pod min/max
+type WorkloadRes struct {
+	Namespace string
+	Kind      string // Deployment, StatefulSet, DaemonSet, CronJob, Job, ReplicaSet, ... or Pod for bare pods
+	Name      string
+	Replicas  int64 // live pods
+	Requests  Resources
+	Limits    Resources
+	Usage     Resources
+}
+
+// Workloads aggregates live pods by workload, sorted by namespace, kind and name.
+// A bare pod is a workload of kind Pod.
+func (s *AllPodResStore) Workloads() []*WorkloadRes {
+	var workloads []*WorkloadRes
+	for _, ns := range s.Namespaces() {
+		byKey := make(map[string]*WorkloadRes)
+		var keys []string
+		for _, pod := range s.Pods(ns) {
+			if pod.Gone {
+				continue
+			}
+			key := pod.Workload
+			if key == "" {
+				key = "Pod/" + pod.Name
+			}
+			w, ok := byKey[key]
+			if !ok {
+				parts := strings.SplitN(key, "/", 2)
+				w = &WorkloadRes{Namespace: ns, Kind: parts[0], Name: parts[1]}
+				byKey[key] = w
+				keys = append(keys, key)
+			}
+			w.Replicas++
+			w.Requests.SumAll(&pod.Requests)
+			w.Limits.SumAll(&pod.Limits)
+			w.Usage.SumAll(&pod.Usage)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			workloads = append(workloads, byKey[key])
+		}
+	}
+	return workloads
+}
@@ -0,0 +1,101 @@
+package process
+
+// total levels of totalsTable rows
+const (
+	totalNamespace = "namespace"
+	totalCluster   = "cluster"
+)
+
+// resTotal current requests, limits and usage summed over live pods
+type resTotal struct {
+	pods     int64
+	requests Resources
+	limits   Resources
+	usage    Resources
+}
+
+func (t *resTotal) add(pod *PodRes) {
+	t.pods++
+	t.requests.AddAll(&pod.Requests)
+	t.limits.AddAll(&pod.Limits)
+	t.usage.AddAll(&pod.Usage)
+}
+
+// totalHeader column names of fields, extended request and limit columns follow like resHeader
+func totalHeader(extended []string) []string {
+	header := []string{"pods"}
+	for _, prefix := range []string{"request_", "limit_", "usage_"} {
+		for _, kind := range ResKinds {
+			header = append(header, prefix+string(kind))
+		}
+	}
+	header = append(header, "usage_request_cpu_ratio", "usage_limit_cpu_ratio", "usage_request_mem_ratio", "usage_limit_mem_ratio")
+	for _, name := range extended {
+		header = append(header, "request_"+name, "limit_"+name)
+	}
+	return header
+}
+
+// fields sums with usage-to-request and usage-to-limit ratios, 0 if there is no request or limit
+func (t *resTotal) fields(extended []string) row {
+	fields := row{t.pods}
+	for _, r := range []*Resources{&t.requests, &t.limits, &t.usage} {
+		for _, kind := range ResKinds {
+			fields = append(fields, r.Get(kind).Current)
+		}
+	}
+	fields = append(fields,
+		ratio(t.usage.CPU.Current, t.requests.CPU.Current), ratio(t.usage.CPU.Current, t.limits.CPU.Current),
+		ratio(t.usage.Mem.Current, t.requests.Mem.Current), ratio(t.usage.Mem.Current, t.limits.Mem.Current))
+	for _, name := range extended {
+		fields = append(fields, intFields(current(&t.requests, name), current(&t.limits, name))...)
+	}
+	return fields
+}
+
+// liveExtendedNames sorted extended resource names requested or limited by live pods
+func liveExtendedNames(pods []*PodRes) []string {
+	var resources []*Resources
+	for _, pod := range pods {
+		if !pod.Gone {
+			resources = append(resources, &pod.Requests, &pod.Limits)
+		}
+	}
+	return extendedNames(resources)
+}
+
+// ratio returns v / total, 0 if total is 0
+func ratio(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) / float64(total)
+}
+
+// totalsTable builds one subtotal row each namespace and a cluster grand total row of live pods,
+// pods are sorted by namespace
+func totalsTable(pods []*PodRes) *table {
+	extended := liveExtendedNames(pods)
+	t := &table{header: append([]string{"level", "namespace"}, totalHeader(extended)...)}
+	cluster := &resTotal{}
+	var ns *resTotal
+	var namespace string
+	for _, pod := range pods {
+		if pod.Gone {
+			continue
+		}
+		if ns == nil || pod.Namespace != namespace {
+			if ns != nil {
+				t.addRow(append(row{totalNamespace, namespace}, ns.fields(extended)...))
+			}
+			ns, namespace = &resTotal{}, pod.Namespace
+		}
+		ns.add(pod)
+		cluster.add(pod)
+	}
+	if ns != nil {
+		t.addRow(append(row{totalNamespace, namespace}, ns.fields(extended)...))
+	}
+	t.addRow(append(row{totalCluster, ""}, cluster.fields(extended)...))
+	return t
+}
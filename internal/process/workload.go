@@ -0,0 +1,51 @@
+package process
+
+import (
+	"k8res/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// podWorkload returns kind/name of the top controller owning a pod, following the owner chain
+// Pod → ReplicaSet → Deployment and Pod → Job → CronJob through the replicaset and job caches.
+// StatefulSet, DaemonSet and other controllers own pods directly. Empty for bare pods.
+// Without the caches (app.ownerChain off, not exported or not watchable) or if an owner is not cached yet, a ReplicaSet
+// named after the pod-template-hash is taken as a Deployment.
+func (c *Collector) podWorkload(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+	nc := c.cache(pod.Namespace)
+	switch owner.Kind {
+	case "ReplicaSet":
+		if nc.rsLister != nil {
+			rs, err := nc.rsLister.ReplicaSets(pod.Namespace).Get(owner.Name)
+			if err == nil {
+				return controllerOf(&rs.ObjectMeta, owner)
+			}
+			logger.Debugf("get replicaset %s/%s of pod %s failed: %v", pod.Namespace, owner.Name, pod.Name, err)
+		}
+		if hash, ok := pod.Labels["pod-template-hash"]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	case "Job":
+		if nc.jobLister != nil {
+			job, err := nc.jobLister.Jobs(pod.Namespace).Get(owner.Name)
+			if err == nil {
+				return controllerOf(&job.ObjectMeta, owner)
+			}
+			logger.Debugf("get job %s/%s of pod %s failed: %v", pod.Namespace, owner.Name, pod.Name, err)
+		}
+	}
+	return owner.Kind + "/" + owner.Name
+}
+
+// controllerOf returns kind/name of the controller of an owner object, the owner itself if it has none
+func controllerOf(meta *metav1.ObjectMeta, owner *metav1.OwnerReference) string {
+	if controller := metav1.GetControllerOfNoCopy(meta); controller != nil {
+		return controller.Kind + "/" + controller.Name
+	}
+	return owner.Kind + "/" + owner.Name
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -19,13 +20,13 @@ func ViperInit(runMode string, envPrefix string) error {
 	if err := viper.ReadInConfig(); err != nil {
 		return err
 	}
-	fmt.Println("Using config file:", viper.ConfigFileUsed())
+	fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 
 	viper.SetConfigFile(getFilename(runMode))
 	if err := viper.MergeInConfig(); err != nil {
 		return err
 	}
-	fmt.Println("Merge config file:", viper.ConfigFileUsed())
+	fmt.Fprintln(os.Stderr, "Merge config file:", viper.ConfigFileUsed())
 
 	checkMissingResourceEnvVars()
 	viper.SetEnvPrefix(envPrefix)
@@ -38,10 +39,10 @@ func getFilename(runMode string) string {
 	if _, err := os.Stat(configFile); err == nil {
 		return configFile
 	}
-	fmt.Printf("WARN: create new config file %s\n", configFile)
+	fmt.Fprintf(os.Stderr, "WARN: create new config file %s\n", configFile)
 	file, err := os.Create(configFile)
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 		return ""
 	}
 	file.Close()
@@ -71,6 +72,10 @@ func GetStringSlice(item string) []string {
 	return viper.GetStringSlice(item)
 }
 
+func GetStringMapStringSlice(item string) map[string][]string {
+	return viper.GetStringMapStringSlice(item)
+}
+
 func Set(key string, value interface{}) {
 	viper.Set(key, value)
 }
@@ -82,3 +87,7 @@ func GetInt(item string) int {
 func GetBool(item string) bool {
 	return viper.GetBool(item)
 }
+
+func GetDuration(item string) time.Duration {
+	return viper.GetDuration(item)
+}
@@ -51,8 +51,9 @@ func Initialize() {
 	} else {
 		encoder = zapcore.NewConsoleEncoder(encoderConfig)
 	}
+	// console logs go to stderr, stdout is kept for export output
 	if viper.GetBool("log.consoleStdout") {
-		syncWriters = append(syncWriters, zapcore.AddSync(os.Stdout))
+		syncWriters = append(syncWriters, zapcore.AddSync(os.Stderr))
 	}
 	if viper.GetBool("log.fileStdout") {
 		syncWriters = append(syncWriters, zapcore.AddSync(fileConfig))
@@ -0,0 +1,172 @@
+// Package sketch mergeable streaming quantile sketch with bounded memory.
+//
+// Sketch follows DDSketch: a positive value v is counted in bucket ceil(log(v)/log(gamma)),
+// gamma = (1+alpha)/(1-alpha), so every quantile is returned with a relative error of at
+// most alpha (default 1%). Values <= 0 are counted apart and returned as 0.
+// Buckets are bounded by MaxBuckets (default 2048, ~16KB), enough to keep alpha for values
+// from 1 to 1e17 with the default alpha. Beyond that the lowest buckets are collapsed,
+// which only affects the accuracy of the lowest quantiles.
+// Two sketches with the same alpha can be merged without extra error.
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	DefaultAlpha      = 0.01
+	DefaultMaxBuckets = 2048
+)
+
+// Sketch quantile sketch, the zero value is ready to use with default alpha and max buckets
+type Sketch struct {
+	Alpha      float64       `json:"alpha"`
+	MaxBuckets int           `json:"maxBuckets"`
+	Count      int64         `json:"count"`
+	Sum        float64       `json:"sum"`
+	Min        int64         `json:"min"`
+	Max        int64         `json:"max"`
+	Zero       int64         `json:"zero"`    // count of values <= 0
+	Buckets    map[int]int64 `json:"buckets"` // bucket index -> count
+}
+
+// New creates a sketch with relative accuracy alpha (0-1) and at most maxBuckets buckets
+func New(alpha float64, maxBuckets int) *Sketch {
+	return &Sketch{Alpha: alpha, MaxBuckets: maxBuckets}
+}
+
+func (s *Sketch) init() {
+	if s.Alpha <= 0 || s.Alpha >= 1 {
+		s.Alpha = DefaultAlpha
+	}
+	if s.MaxBuckets <= 0 {
+		s.MaxBuckets = DefaultMaxBuckets
+	}
+	if s.Buckets == nil {
+		s.Buckets = make(map[int]int64)
+	}
+}
+
+func (s *Sketch) gamma() float64 {
+	return (1 + s.Alpha) / (1 - s.Alpha)
+}
+
+func (s *Sketch) index(v int64) int {
+	return int(math.Ceil(math.Log(float64(v)) / math.Log(s.gamma())))
+}
+
+// value returns the estimated value of bucket i, within alpha of every value in it
+func (s *Sketch) value(i int) int64 {
+	g := s.gamma()
+	return int64(math.Round(2 * math.Pow(g, float64(i)) / (g + 1)))
+}
+
+// Add records a value
+func (s *Sketch) Add(v int64) {
+	s.init()
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += float64(v)
+	if v <= 0 {
+		s.Zero++
+		return
+	}
+	s.Buckets[s.index(v)]++
+	s.collapse()
+}
+
+// Mean returns the average of values, 0 if empty
+func (s *Sketch) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Quantile returns the q (0-1) quantile, 0 if empty
+func (s *Sketch) Quantile(q float64) int64 {
+	if s.Count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+	rank := int64(q * float64(s.Count-1))
+	if rank < s.Zero {
+		return 0
+	}
+	seen := s.Zero
+	for _, i := range s.indexes() {
+		seen += s.Buckets[i]
+		if seen > rank {
+			v := s.value(i)
+			// the estimate may fall outside of the observed range
+			if v < s.Min {
+				return s.Min
+			}
+			if v > s.Max {
+				return s.Max
+			}
+			return v
+		}
+	}
+	return s.Max
+}
+
+// Merge adds all values of o, both must have the same alpha
+func (s *Sketch) Merge(o *Sketch) error {
+	if o.Count == 0 {
+		return nil
+	}
+	s.init()
+	if o.Alpha != s.Alpha {
+		return fmt.Errorf("merge sketch with alpha %v into alpha %v", o.Alpha, s.Alpha)
+	}
+	if s.Count == 0 || o.Min < s.Min {
+		s.Min = o.Min
+	}
+	if s.Count == 0 || o.Max > s.Max {
+		s.Max = o.Max
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Zero += o.Zero
+	for i, n := range o.Buckets {
+		s.Buckets[i] += n
+	}
+	s.collapse()
+	return nil
+}
+
+// collapse merges the lowest buckets until there are at most MaxBuckets
+func (s *Sketch) collapse() {
+	if len(s.Buckets) <= s.MaxBuckets {
+		return
+	}
+	indexes := s.indexes()
+	n := len(indexes) - s.MaxBuckets
+	target := indexes[n]
+	for _, i := range indexes[:n] {
+		s.Buckets[target] += s.Buckets[i]
+		delete(s.Buckets, i)
+	}
+}
+
+func (s *Sketch) indexes() []int {
+	indexes := make([]int, 0, len(s.Buckets))
+	for i := range s.Buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
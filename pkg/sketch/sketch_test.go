@@ -0,0 +1,141 @@
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// logUniform returns n values spread over [min, max) on a log scale
+func logUniform(r *rand.Rand, n int, min, max float64) []int64 {
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = int64(math.Exp(math.Log(min) + r.Float64()*(math.Log(max)-math.Log(min))))
+	}
+	return values
+}
+
+// exactQuantile returns the value of sorted values at the rank Quantile uses
+func exactQuantile(sorted []int64, q float64) int64 {
+	return sorted[int64(q*float64(len(sorted)-1))]
+}
+
+func relativeError(got, want int64) float64 {
+	return math.Abs(float64(got-want)) / float64(want)
+}
+
+var quantiles = []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999}
+
+func TestQuantileRelativeError(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// values from 1000 so rounding bucket values to int64 stays far below alpha
+	values := logUniform(r, 100000, 1e3, 1e12)
+	var s Sketch
+	for _, v := range values {
+		s.Add(v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, q := range quantiles {
+		want := exactQuantile(values, q)
+		if got := s.Quantile(q); relativeError(got, want) > DefaultAlpha {
+			t.Errorf("quantile %v = %d, want %d within %v", q, got, want, DefaultAlpha)
+		}
+	}
+	if s.Quantile(0) != values[0] || s.Quantile(1) != values[len(values)-1] {
+		t.Errorf("quantile 0, 1 = %d, %d, want min %d, max %d", s.Quantile(0), s.Quantile(1), values[0], values[len(values)-1])
+	}
+}
+
+func TestZeroValues(t *testing.T) {
+	var s Sketch
+	for _, v := range []int64{0, 0, -5, 100, 200} {
+		s.Add(v)
+	}
+	if got := s.Quantile(0.5); got != 0 {
+		t.Errorf("median of mostly zero values = %d, want 0", got)
+	}
+	if s.Zero != 3 || s.Count != 5 {
+		t.Errorf("zero, count = %d, %d, want 3, 5", s.Zero, s.Count)
+	}
+}
+
+func TestMergeWithoutExtraError(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	values := logUniform(r, 50000, 1e3, 1e9)
+	var all, a, b Sketch
+	for i, v := range values {
+		all.Add(v)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	if err := a.Merge(&b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != all.Count || a.Sum != all.Sum || a.Min != all.Min || a.Max != all.Max {
+		t.Errorf("merged count/sum/min/max = %d/%v/%d/%d, want %d/%v/%d/%d",
+			a.Count, a.Sum, a.Min, a.Max, all.Count, all.Sum, all.Min, all.Max)
+	}
+	for _, q := range quantiles {
+		if got, want := a.Quantile(q), all.Quantile(q); got != want {
+			t.Errorf("merged quantile %v = %d, want %d of a single sketch", q, got, want)
+		}
+	}
+}
+
+func TestMergeIntoZeroValue(t *testing.T) {
+	var s, o Sketch
+	o.Add(1000)
+	if err := s.Merge(&o); err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 1 || s.Quantile(0.5) != 1000 {
+		t.Errorf("count, median = %d, %d, want 1, 1000", s.Count, s.Quantile(0.5))
+	}
+}
+
+func TestMergeAlphaMismatch(t *testing.T) {
+	a, b := New(0.01, 0), New(0.02, 0)
+	a.Add(10)
+	b.Add(10)
+	if err := a.Merge(b); err == nil {
+		t.Error("merge of sketches with different alpha, want error")
+	}
+}
+
+func TestCollapseKeepsHighQuantiles(t *testing.T) {
+	const maxBuckets = 100
+	r := rand.New(rand.NewSource(3))
+	values := logUniform(r, 20000, 1e3, 1e15)
+	s := New(DefaultAlpha, maxBuckets)
+	for _, v := range values {
+		s.Add(v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	if len(s.Buckets) > maxBuckets {
+		t.Errorf("buckets = %d, want at most %d", len(s.Buckets), maxBuckets)
+	}
+	var counted int64
+	for _, n := range s.Buckets {
+		counted += n
+	}
+	if counted != s.Count || s.Count != int64(len(values)) {
+		t.Errorf("bucket counts = %d, count = %d, want %d", counted, s.Count, len(values))
+	}
+	// the top buckets are never collapsed
+	for _, q := range []float64{0.99, 0.999} {
+		want := exactQuantile(values, q)
+		if got := s.Quantile(q); relativeError(got, want) > DefaultAlpha {
+			t.Errorf("quantile %v = %d, want %d within %v", q, got, want, DefaultAlpha)
+		}
+	}
+	// collapsed low values are over estimated, never under the true value
+	if got, want := s.Quantile(0.01), exactQuantile(values, 0.01); got < want {
+		t.Errorf("collapsed quantile 0.01 = %d, want at least %d", got, want)
+	}
+}
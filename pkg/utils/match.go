@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix prefix of a regular expression pattern, other patterns are globs
+const regexPrefix = "re:"
+
+// globExpr regular expression of a glob, '*' matches any characters including '/', '?' one character
+func globExpr(pattern string) string {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return "^" + expr + "$"
+}
+
+// Matcher matches names with globs or "re:" prefixed regular expressions
+type Matcher struct {
+	exprs []*regexp.Regexp
+}
+
+// NewMatcher compiles patterns, a glob or a regular expression with "re:" prefix each
+func NewMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, pattern := range patterns {
+		expr := globExpr(pattern)
+		if strings.HasPrefix(pattern, regexPrefix) {
+			expr = strings.TrimPrefix(pattern, regexPrefix)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		m.exprs = append(m.exprs, re)
+	}
+	return m, nil
+}
+
+// Match reports whether s matches one of the patterns
+func (m *Matcher) Match(s string) bool {
+	for _, re := range m.exprs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 )
 
 var (
@@ -23,11 +24,11 @@ func getVersion() string {
 	return Version
 }
 
-// PrintFullVersion print full version
-func PrintFullVersion() {
-	fmt.Println("Version:          ", getVersion())
-	fmt.Println("Git Branch:       ", GitBranch)
-	fmt.Println("Git Commit:       ", GitHash)
-	fmt.Println("Build Time (UTC): ", BuildTS)
-	fmt.Println("")
+// PrintFullVersion print full version to w
+func PrintFullVersion(w io.Writer) {
+	fmt.Fprintln(w, "Version:          ", getVersion())
+	fmt.Fprintln(w, "Git Branch:       ", GitBranch)
+	fmt.Fprintln(w, "Git Commit:       ", GitHash)
+	fmt.Fprintln(w, "Build Time (UTC): ", BuildTS)
+	fmt.Fprintln(w, "")
 }